@@ -72,8 +72,9 @@ func (c *Client) Initialize(ctx context.Context) (*InitializeResponse, error) {
 }
 
 type ChairsResponse struct {
-	Count  int64         `json:"count"`
-	Chairs []asset.Chair `json:"chairs"`
+	Count      int64         `json:"count"`
+	Chairs     []asset.Chair `json:"chairs"`
+	NextCursor string        `json:"nextCursor,omitempty"`
 }
 
 type EstatesResponse struct {
@@ -265,6 +266,23 @@ func (c *Client) SearchChairsWithQuery(ctx context.Context, q url.Values) (*Chai
 	return &chairs, nil
 }
 
+// SearchChairsWithCursor page の代わりに cursor で続きのページを取得する
+// cursor が空文字列の場合は先頭のページを取得する
+func (c *Client) SearchChairsWithCursor(ctx context.Context, q url.Values, cursor string) (*ChairsResponse, error) {
+	cq := url.Values{}
+	for k, v := range q {
+		cq[k] = v
+	}
+	cq.Del("page")
+	if cursor == "" {
+		cq.Set("page", "0")
+	} else {
+		cq.Set("cursor", cursor)
+	}
+
+	return c.SearchChairsWithQuery(ctx, cq)
+}
+
 func (c *Client) GetEstateSearchCondition(ctx context.Context) (*asset.EstateSearchCondition, error) {
 	req, err := c.newGetRequest(ShareTargetURLs.AppURL, "/api/estate/search/condition")
 	if err != nil {
@@ -614,6 +632,48 @@ func (c *Client) GetRecommendedEstatesFromChair(ctx context.Context, id int64) (
 	return &estate, nil
 }
 
+func (c *Client) GetRecommendedChairsFromEstate(ctx context.Context, id int64) (*ChairsResponse, error) {
+	req, err := c.newGetRequest(ShareTargetURLs.AppURL, "/api/recommended_chair/"+strconv.FormatInt(id, 10))
+	if err != nil {
+		return nil, failure.Translate(err, fails.ErrBenchmarker)
+	}
+
+	req = req.WithContext(ctx)
+	res, err := c.Do(req)
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, failure.Wrap(err, failure.Message("GET /api/recommended_chair/:id: リクエストに失敗しました"))
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	err = checkStatusCode(res, []int{http.StatusOK})
+	if err != nil {
+		if c.isBot {
+			return nil, failure.Translate(err, fails.ErrBot)
+		}
+		return nil, failure.Wrap(err, failure.Message("GET /api/recommended_chair/:id: レスポンスコードが不正です"))
+	}
+
+	var chairs ChairsResponse
+
+	err = json.NewDecoder(res.Body).Decode(&chairs)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if nerr, ok := err.(interface{ Timeout() bool }); ok && nerr.Timeout() {
+			return nil, failure.Translate(err, fails.ErrTimeout, failure.Message("GET /api/recommended_chair/:id: リクエストに失敗しました"))
+		}
+		return nil, failure.Wrap(err, failure.Message("GET /api/recommended_chair/:id: JSONデコードに失敗しました"))
+	}
+
+	return &chairs, nil
+}
+
 type EmailRequest struct {
 	Email string `json:"email"`
 }
@@ -658,6 +718,59 @@ func (c *Client) BuyChair(ctx context.Context, id string) error {
 	return nil
 }
 
+type CartItem struct {
+	ID       int64 `json:"id"`
+	Quantity int64 `json:"quantity"`
+}
+
+type BuyChairsRequest struct {
+	Email string     `json:"email"`
+	Items []CartItem `json:"items"`
+}
+
+func (c *Client) BuyChairs(ctx context.Context, items []CartItem) error {
+	jsonStr, err := json.Marshal(BuyChairsRequest{Email: c.GetEmail(), Items: items})
+	if err != nil {
+		return failure.Translate(err, fails.ErrBenchmarker)
+	}
+
+	req, err := c.newPostRequest(ShareTargetURLs.AppURL, "/api/chair/buy", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return failure.Translate(err, fails.ErrBenchmarker)
+	}
+
+	req = req.WithContext(ctx)
+	res, err := c.Do(req)
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return failure.Wrap(err, failure.Message("POST /api/chair/buy: リクエストに失敗しました"))
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	err = checkStatusCode(res, []int{http.StatusOK})
+	if err != nil {
+		if c.isBot {
+			return failure.Translate(err, fails.ErrBot)
+		}
+		return failure.Wrap(err, failure.Message("POST /api/chair/buy: リクエストに失敗しました"))
+	}
+
+	for _, item := range items {
+		for i := int64(0); i < item.Quantity; i++ {
+			asset.DecrementChairStock(item.ID)
+			if !c.isBot {
+				score.IncrementScore()
+			}
+		}
+	}
+
+	return nil
+}
+
 func (c *Client) RequestEstateDocument(ctx context.Context, id string) error {
 	jsonStr, err := json.Marshal(EmailRequest{Email: c.GetEmail()})
 	if err != nil {
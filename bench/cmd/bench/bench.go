@@ -41,10 +41,12 @@ func main() {
 	conf := Config{}
 	dataDir := ""
 	fixtureDir := ""
+	checkRecommendedChair := false
 
 	flags.StringVar(&conf.TargetURLStr, "target-url", "http://localhost:1323", "target url")
 	flags.StringVar(&dataDir, "data-dir", "../initial-data", "data directory")
 	flags.StringVar(&fixtureDir, "fixture-dir", "../webapp/fixture", "fixture directory")
+	flags.BoolVar(&checkRecommendedChair, "check-recommended-chair", false, "verify GET /api/recommended_chair/:id (only for implementations that have the endpoint)")
 
 	err := flags.Parse(os.Args[1:])
 	if err != nil {
@@ -88,7 +90,7 @@ func main() {
 	reporter.SetLanguage(initRes.Language)
 
 	log.Println("=== verify ===")
-	scenario.Verify(context.Background(), dataDir, fixtureDir)
+	scenario.Verify(context.Background(), dataDir, fixtureDir, checkRecommendedChair)
 	msgs = fails.GetMsgs()
 	if len(msgs) > 0 {
 		log.Println("verify failed")
@@ -67,6 +67,38 @@ func checkRecommendedEstates(estates []asset.Estate, chair *asset.Chair) error {
 	return nil
 }
 
+func checkRecommendedChairs(chairs []asset.Chair, estate *asset.Estate, t time.Time) error {
+	shorterDoorLen, longerDoorLen := estate.DoorWidth, estate.DoorHeight
+	if shorterDoorLen > longerDoorLen {
+		shorterDoorLen, longerDoorLen = longerDoorLen, shorterDoorLen
+	}
+
+	var popularity int64 = -1
+	for i, chair := range chairs {
+		chair, err := asset.GetChairFromID(chair.ID)
+		if err != nil {
+			return err
+		}
+
+		lengths := [3]int64{chair.Width, chair.Height, chair.Depth}
+		sort.Slice(lengths[:], func(i, j int) bool { return lengths[i] < lengths[j] })
+		if lengths[0] > shorterDoorLen || lengths[1] > longerDoorLen {
+			return fmt.Errorf("ドアを通過できないイスがおすすめされています")
+		}
+
+		if err := checkChairInStock(chair, t); err != nil {
+			return err
+		}
+
+		p := chair.GetPopularity()
+		if i > 0 && popularity < p {
+			return fmt.Errorf("イスがpopularity順に並んでいません")
+		}
+		popularity = p
+	}
+	return nil
+}
+
 func checkEstatesOrderedByPopularity(e []asset.Estate) error {
 	var popularity int64 = -1
 	for i, estate := range e {
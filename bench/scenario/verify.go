@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/isucon10-qualify/isucon10-qualify/bench/asset"
 	"github.com/isucon10-qualify/isucon10-qualify/bench/client"
@@ -18,7 +19,8 @@ import (
 // Verify Initialize後のアプリケーションサーバーに対して、副作用のない検証を実行する
 // 早い段階でベンチマークをFailさせて早期リターンさせるのが目的
 // ex) Search API を叩いて初期状態を確認する
-func Verify(ctx context.Context, dataDir, fixtureDir string) {
+// checkRecommendedChair が true のときは、おすすめイスAPIを実装している実装向けにその検証も行う
+func Verify(ctx context.Context, dataDir, fixtureDir string, checkRecommendedChair bool) {
 	ctx, cancel := context.WithTimeout(ctx, parameter.VerifyTimeout)
 	defer cancel()
 
@@ -48,6 +50,16 @@ func Verify(ctx context.Context, dataDir, fixtureDir string) {
 		fails.Add(err)
 	}
 
+	if checkRecommendedChair {
+		if err := verifyRecommendedChairs(ctx, c); err != nil {
+			fails.Add(err)
+		}
+		if ctx.Err() != nil {
+			err := failure.New(fails.ErrCritical, failure.Message("アプリケーション互換性チェックがタイムアウトしました"))
+			fails.Add(err)
+		}
+	}
+
 	cancel()
 	<-doneChan
 	for {
@@ -148,6 +160,39 @@ func verifyChairStock(ctx context.Context, c *client.Client, id int64) error {
 	return nil
 }
 
+// verifyRecommendedChairs トップページの物件それぞれについて、おすすめイスがドアを通り、在庫があり、人気順に並んでいることを確認する
+func verifyRecommendedChairs(ctx context.Context, c *client.Client) error {
+	_, er, err := c.AccessTopPage(ctx)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return failure.Translate(err, fails.ErrApplication, failure.Message("物件一覧の取得に失敗しました"))
+	}
+
+	for _, e := range er.Estates {
+		estate, err := asset.GetEstateFromID(e.ID)
+		if err != nil {
+			return failure.Translate(err, fails.ErrApplication, failure.Message("GET /api/estate/low_priced: レスポンスの内容が不正です"))
+		}
+
+		t := time.Now()
+		rc, err := c.GetRecommendedChairsFromEstate(ctx, e.ID)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return failure.Translate(err, fails.ErrApplication, failure.Message("おすすめイスの取得に失敗しました"))
+		}
+
+		if err := checkRecommendedChairs(rc.Chairs, estate, t); err != nil {
+			return failure.Translate(err, fails.ErrApplication, failure.Message("GET /api/recommended_chair/:id: レスポンスの内容が不正です"))
+		}
+	}
+
+	return nil
+}
+
 func verifyWithScenario(ctx context.Context, c *client.Client, fixtureDir, snapshotsParentsDirPath string) {
 	var (
 		estates []asset.Estate
@@ -39,6 +39,7 @@ var (
 	ignoreEstateUnexported = cmpopts.IgnoreUnexported(asset.Estate{})
 	ignoreEstateLatitude   = cmpopts.IgnoreFields(asset.Estate{}, "Latitude")
 	ignoreEstateLongitude  = cmpopts.IgnoreFields(asset.Estate{}, "Longitude")
+	ignoreChairsNextCursor = cmpopts.IgnoreFields(client.ChairsResponse{}, "NextCursor")
 )
 
 type Request struct {
@@ -182,7 +183,7 @@ func verifyChairSearch(ctx context.Context, c *client.Client, filePath string) e
 			return failure.Translate(err, fails.ErrBenchmarker, failure.Message("GET /api/chair/search: SnapshotのResponse BodyのUnmarshalでエラーが発生しました"), failure.Messagef("snapshot: %s", filePath))
 		}
 
-		if !cmp.Equal(*expected, *actual, ignoreChairUnexported) {
+		if !cmp.Equal(*expected, *actual, ignoreChairUnexported, ignoreChairsNextCursor) {
 			return failure.New(fails.ErrApplication, failure.Message("GET /api/chair/search: レスポンスが不正です"), failure.Messagef("snapshot: %s", filePath))
 		}
 
@@ -325,7 +326,7 @@ func verifyLowPricedChair(ctx context.Context, c *client.Client, filePath string
 			return failure.Translate(err, fails.ErrBenchmarker, failure.Message("GET /api/chair/low_priced: SnapshotのResponse BodyのUnmarshalでエラーが発生しました"), failure.Messagef("snapshot: %s", filePath))
 		}
 
-		if !cmp.Equal(*expected, *actual, ignoreChairUnexported) {
+		if !cmp.Equal(*expected, *actual, ignoreChairUnexported, ignoreChairsNextCursor) {
 			return failure.New(fails.ErrApplication, failure.Message("GET /api/chair/low_priced: レスポンスが不正です"), failure.Messagef("snapshot: %s", filePath))
 		}
 
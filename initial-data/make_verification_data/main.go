@@ -19,6 +19,7 @@ const (
 	NumOfEstateSearchData               = 100
 	NumOfRecommendedEstateWithChairData = 100
 	NumOfEstatesNazotteData             = 100
+	NumOfEstatesNazotteConcaveData      = 50
 )
 
 func init() {
@@ -289,6 +290,24 @@ func main() {
 			wg.Done()
 		}(i)
 	}
+	// 凹多角形のデータ (穴なし)
+	// ファイル名の ID は凸包のデータに続けて振る
+	for i := 0; i < NumOfEstatesNazotteConcaveData; i++ {
+		wg.Add(1)
+		go func(id int) {
+			req := Request{
+				Method:   "POST",
+				Resource: "/api/estate/nazotte",
+				Query:    "",
+				Body:     createRandomConcavePolygon(),
+			}
+
+			snapshot := getSnapshotFromRequest(TargetServer, req)
+			fileName := fmt.Sprintf("%d.json", NumOfEstatesNazotteData+id)
+			writeSnapshotDataToFile(filepath.Join(DestDirectoryPath, "estate_nazotte", fileName), snapshot)
+			wg.Done()
+		}(i)
+	}
 	wg.Wait()
 	log.Println("Done generating verification data of /api/estate/nazotte")
 }
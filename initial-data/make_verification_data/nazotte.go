@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"math"
 	"math/rand"
 	"sort"
 )
@@ -111,3 +112,54 @@ func createRandomConvexhull() string {
 
 	return string(body)
 }
+
+const (
+	concaveOuterMinRadius = 0.7
+	concaveOuterMaxRadius = 1.0
+	concaveInnerMinRadius = 0.2
+	concaveInnerMaxRadius = 0.5
+)
+
+// createRandomConcavePolygon 中心から見て頂点が角度順に並ぶ星形の多角形を作る
+// 頂点の中心からの距離を交互に遠く・近くすることで必ず凹になり、角度順なので自己交差もしない
+func createRandomConcavePolygon() string {
+	famousPlace := FamousPlaces[rand.Intn(len(FamousPlaces))]
+
+	width := rand.Float64()*(rangeMaxWidth-rangeMinWidth) + rangeMinWidth
+	height := rand.Float64()*(rangeMaxHeight-rangeMinHeight) + rangeMinHeight
+	center := Coordinate{
+		Latitude:  famousPlace.Latitude + (rand.Float64()-0.5)*rangeDiffLatitude,
+		Longitude: famousPlace.Longitude + (rand.Float64()-0.5)*rangeDiffLongitude,
+	}
+
+	pointCounts := rand.Intn(numOfMaxPoints-numOfMinPoints) + numOfMinPoints
+	if pointCounts%2 == 1 {
+		pointCounts++
+	}
+
+	step := 2 * math.Pi / float64(pointCounts)
+	coordinates := []Coordinate{}
+	for i := 0; i < pointCounts; i++ {
+		angle := (float64(i) + (rand.Float64()-0.5)*0.5) * step
+		var r float64
+		if i%2 == 0 {
+			r = rand.Float64()*(concaveOuterMaxRadius-concaveOuterMinRadius) + concaveOuterMinRadius
+		} else {
+			r = rand.Float64()*(concaveInnerMaxRadius-concaveInnerMinRadius) + concaveInnerMinRadius
+		}
+		coordinates = append(coordinates, Coordinate{
+			Latitude:  center.Latitude + math.Cos(angle)*r*width/2,
+			Longitude: center.Longitude + math.Sin(angle)*r*height/2,
+		})
+	}
+	coordinates = append(coordinates, coordinates[0])
+
+	body, err := json.Marshal(NazotteRequestBody{
+		Coordinates: coordinates,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,120 @@
+package botfilter
+
+import (
+	"bufio"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/labstack/echo"
+)
+
+// DefaultPatterns ベンチマーカーが送ってくるボットの User-Agent にマッチする正規表現
+var DefaultPatterns = []string{
+	`ISUCONbot(-Mobile)?`,
+	`ISUCONbot-Image\/`,
+	`Mediapartners-ISUCON`,
+	`ISUCONCoffee`,
+	`ISUCONFeedSeeker(Beta)?`,
+	`crawler \(https:\/\/isucon\.invalid\/(support\/faq\/|help\/jp\/)`,
+	`isubot`,
+	`Isupider`,
+	`Isupider(-image)?\+`,
+	`(?i)(bot|crawler|spider)(?:[-_ .\/;@()]|$)`,
+}
+
+// Filter User-Agent がいずれかのパターンにマッチするリクエストを 503 で拒否する
+type Filter struct {
+	patterns []*regexp.Regexp
+	// rejected[i] は patterns[i] にマッチして拒否したリクエスト数
+	rejected []uint64
+}
+
+// New patterns をコンパイルして Filter を作る
+func New(patterns []string) (*Filter, error) {
+	f := &Filter{
+		patterns: make([]*regexp.Regexp, 0, len(patterns)),
+		rejected: make([]uint64, len(patterns)),
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, re)
+	}
+	return f, nil
+}
+
+// LoadPatterns 1行に1つ正規表現を書いたファイルを読み込む
+// 空行と # で始まる行は無視する
+func LoadPatterns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// Match ua にマッチした最初のパターンの位置を返す。マッチしなければ -1
+func (f *Filter) Match(ua string) int {
+	for i, re := range f.patterns {
+		if re.MatchString(ua) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Middleware ボットからのリクエストを後続のハンドラに渡さずに 503 を返す
+func (f *Filter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if i := f.Match(c.Request().UserAgent()); i >= 0 {
+				atomic.AddUint64(&f.rejected[i], 1)
+				return c.NoContent(http.StatusServiceUnavailable)
+			}
+			return next(c)
+		}
+	}
+}
+
+// PatternStat パターンごとの拒否数
+type PatternStat struct {
+	Pattern  string `json:"pattern"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// Stats パターンごとの拒否数を返す
+func (f *Filter) Stats() []PatternStat {
+	stats := make([]PatternStat, 0, len(f.patterns))
+	for i, re := range f.patterns {
+		stats = append(stats, PatternStat{Pattern: re.String(), Rejected: atomic.LoadUint64(&f.rejected[i])})
+	}
+	return stats
+}
+
+// Rejected 拒否したリクエストの総数を返す
+func (f *Filter) Rejected() uint64 {
+	var total uint64
+	for i := range f.rejected {
+		total += atomic.LoadUint64(&f.rejected[i])
+	}
+	return total
+}
@@ -0,0 +1,209 @@
+package botfilter
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+const uuidStr = "3f1c6a2e-8d4b-4e5f-9a7c-0b1d2e3f4a5b"
+
+// botUserAgentFormats, botUserAgentMains, botUserAgentSuffixes は bench/client/useragent.go の GenerateBotUserAgent から写したもの
+// ベンチマーカーの形式が変わったら TestBotUserAgents_MatchBench が失敗するので、合わせて更新する
+
+// botUserAgentFormats uuid を埋め込んでそのまま返す形式
+var botUserAgentFormats = []string{
+	"ISUCONbot-Mobile-%v",
+	"ISUCONbot-%v",
+	"ISUCONbot-Image/%v",
+	"Mediapartners-ISUCON-%v",
+	"%v-ISUCONCoffee",
+	"%v-ISUCONFeedSeekerBeta",
+	"%v-ISUCONFeedSeeker",
+	"crawler (https://isucon.invalid/support/faq/) %v",
+	"crawler (https://isucon.invalid/help/jp/) %v",
+	"isubot-%v",
+	"Isupider-%v",
+	"Isupider-image+%v",
+	"Isupider+%v",
+}
+
+// botUserAgentMains main に入る文字列
+var botUserAgentMains = []string{"bot", "Bot", "BOT", "crawler", "Crawler", "CRAWLER", "spider", "Spider", "SPIDER"}
+
+// botUserAgentSuffixes main の後ろに付ける uuid の形式。")" で始まる場合は main が "(" で始まる
+var botUserAgentSuffixes = []string{"-%v", "_%v", " %v", ".%v", "/%v", ";%v", "@%v", "(%v)", ") %v"}
+
+// botUserAgents GenerateBotUserAgent が生成しうるすべての形式
+func botUserAgents() []string {
+	uas := []string{}
+	for _, format := range botUserAgentFormats {
+		uas = append(uas, fmt.Sprintf(format, uuidStr))
+	}
+	for _, main := range botUserAgentMains {
+		for _, suffix := range botUserAgentSuffixes {
+			if strings.HasPrefix(suffix, ")") {
+				uas = append(uas, fmt.Sprintf("(%v%v", main, fmt.Sprintf(suffix, uuidStr)))
+				continue
+			}
+			uas = append(uas, fmt.Sprintf("%v%v", main, fmt.Sprintf(suffix, uuidStr)))
+		}
+		uas = append(uas, fmt.Sprintf("%v %v", uuidStr, main))
+	}
+	return uas
+}
+
+// TestBotUserAgents_MatchBench botUserAgents の元にした形式が GenerateBotUserAgent と一致していること
+func TestBotUserAgents_MatchBench(t *testing.T) {
+	path := filepath.Join("..", "..", "..", "bench", "client", "useragent.go")
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		t.Fatal("failed to parse useragent.go:", err)
+	}
+
+	var formats, mains, suffixes, others []string
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Name.Name != "GenerateBotUserAgent" {
+			continue
+		}
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.ReturnStmt:
+				if format, ok := uuidFormat(n.Results[0]); ok {
+					formats = append(formats, format)
+					return false
+				}
+			case *ast.AssignStmt:
+				name := n.Lhs[0].(*ast.Ident).Name
+				if lit, ok := stringLit(n.Rhs[0]); ok && name == "main" {
+					mains = append(mains, lit)
+					return false
+				}
+				if format, ok := uuidFormat(n.Rhs[0]); ok && name == "suffix" {
+					suffixes = append(suffixes, format)
+					return false
+				}
+			case *ast.CallExpr:
+				// main と suffix を組み立てる形式
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == "Sprintf" {
+					if lit, ok := stringLit(n.Args[0]); ok {
+						others = append(others, lit)
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	tests := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{name: "formats", got: formats, expected: botUserAgentFormats},
+		{name: "mains", got: mains, expected: botUserAgentMains},
+		{name: "suffixes", got: suffixes, expected: botUserAgentSuffixes},
+		{name: "others", got: others, expected: []string{"(%v", "%v %v", "%v%v"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.expected) {
+			t.Errorf("%v in useragent.go changed.\nexpected: %q\nbut got:  %q", tt.name, tt.expected, tt.got)
+		}
+	}
+}
+
+// uuidFormat fmt.Sprintf(format, uuidStr) の format を返す
+func uuidFormat(expr ast.Expr) (string, bool) {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) != 2 {
+		return "", false
+	}
+	if arg, ok := call.Args[1].(*ast.Ident); !ok || arg.Name != "uuidStr" {
+		return "", false
+	}
+	return stringLit(call.Args[0])
+}
+
+func stringLit(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	s, err := strconv.Unquote(lit.Value)
+	return s, err == nil
+}
+
+// userAgents bench/client/useragent.go の GenerateUserAgent が生成しうるすべての形式
+func userAgents() []string {
+	browsers := []string{"ISUCON Nickel", "ISUCON Icetanuki", "ISUCON Web Browser", "ISUCON Explorer", "ISUCON Edge"}
+	suffixes := []string{" mobile", " bottle", " alpha", " beta", ""}
+
+	uas := []string{}
+	for _, browser := range browsers {
+		for _, suffix := range suffixes {
+			uas = append(uas, fmt.Sprintf("%v%v-%v", browser, suffix, uuidStr))
+		}
+	}
+	return uas
+}
+
+func serve(t *testing.T, f *Filter, ua string) int {
+	e := echo.New()
+	e.Use(f.Middleware())
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", ua)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestFilter_Middleware(t *testing.T) {
+	f, err := New(DefaultPatterns)
+	if err != nil {
+		t.Fatal("failed to compile patterns:", err)
+	}
+
+	bots := botUserAgents()
+	for _, ua := range bots {
+		if code := serve(t, f, ua); code != http.StatusServiceUnavailable {
+			t.Errorf("bot user agent was not rejected. ua: %v, status: %v", ua, code)
+		}
+	}
+	for _, ua := range userAgents() {
+		if code := serve(t, f, ua); code != http.StatusOK {
+			t.Errorf("user agent was rejected. ua: %v, status: %v", ua, code)
+		}
+	}
+
+	if got := f.Rejected(); got != uint64(len(bots)) {
+		t.Errorf("unexpected rejected count. expected: %v, but got: %v", len(bots), got)
+	}
+	var sum uint64
+	for _, s := range f.Stats() {
+		sum += s.Rejected
+	}
+	if sum != f.Rejected() {
+		t.Errorf("sum of stats %v does not match rejected count %v", sum, f.Rejected())
+	}
+}
+
+func TestNew_InvalidPattern(t *testing.T) {
+	if _, err := New([]string{`(`}); err == nil {
+		t.Error("expected an error for invalid pattern")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// testChairID 初期データと衝突しないテスト用のイスの ID
+const testChairID = 2147483000
+
+// setupTestDB MYSQL_* の環境変数で指定した DB に接続する。接続できなければテストをスキップする
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	d, err := NewMySQLConnectionEnv().ConnectDB()
+	if err != nil {
+		t.Skipf("MySQL is not available: %v", err)
+	}
+	if err := d.Ping(); err != nil {
+		d.Close()
+		t.Skipf("MySQL is not available: %v", err)
+	}
+	saved := db
+	db = d
+	t.Cleanup(func() {
+		db = saved
+		d.Close()
+	})
+}
+
+// insertTestChair テスト用のイスを登録し、テストの終わりに関連する行とともに削除する
+func insertTestChair(t *testing.T, stock int64, kind string) {
+	t.Helper()
+	cleanup := func() {
+		db.Exec("DELETE FROM chair WHERE id = ?", testChairID)
+		db.Exec("DELETE FROM chair_order WHERE chair_id = ?", testChairID)
+		db.Exec("DELETE FROM chair_buy_idempotency WHERE chair_id = ?", testChairID)
+		db.Exec("DELETE FROM chair_reservation WHERE chair_id = ?", testChairID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	_, err := db.Exec("INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		testChairID, "test", "test", "/images/chair/test.png", 1000, 100, 100, 100, "白", "", kind, 0, stock)
+	if err != nil {
+		t.Fatal("failed to insert chair:", err)
+	}
+}
+
+func TestBuyChair_IdempotencyKeyConcurrent(t *testing.T) {
+	setupTestDB(t)
+
+	const stock = 5
+	key := fmt.Sprintf("buy-chair-test-%d", time.Now().UnixNano())
+	insertTestChair(t, stock, "ゲーミングチェア")
+
+	e := echo.New()
+	e.POST("/api/chair/buy/:id", buyChair)
+
+	const concurrency = 20
+	codes := make([]int, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/chair/buy/%d", testChairID), strings.NewReader(`{"email":"test@example.com"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set(IdempotencyKeyHeader, key)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %v: unexpected status. expected: %v, but got: %v", i, http.StatusOK, code)
+		}
+	}
+
+	var got int
+	if err := db.Get(&got, "SELECT stock FROM chair WHERE id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if got != stock-1 {
+		t.Errorf("unexpected stock. expected: %v, but got: %v", stock-1, got)
+	}
+
+	var orders int
+	if err := db.Get(&orders, "SELECT COUNT(*) FROM chair_order WHERE chair_id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if orders != 1 {
+		t.Errorf("unexpected number of orders. expected: 1, but got: %v", orders)
+	}
+}
+
+// Idempotency-Key は購入者ごとに区別されるので、別の購入者が同じキーを使っても再送とはみなさない
+func TestBuyChair_IdempotencyKeyPerBuyer(t *testing.T) {
+	setupTestDB(t)
+
+	const stock = 5
+	key := fmt.Sprintf("buy-chair-test-%d", time.Now().UnixNano())
+	insertTestChair(t, stock, "ゲーミングチェア")
+
+	e := echo.New()
+	e.POST("/api/chair/buy/:id", buyChair)
+
+	for _, email := range []string{"a@example.com", "b@example.com", "a@example.com"} {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/chair/buy/%d", testChairID), strings.NewReader(fmt.Sprintf(`{"email":%q}`, email)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v: unexpected status. expected: %v, but got: %v", email, http.StatusOK, rec.Code)
+		}
+	}
+
+	var got int
+	if err := db.Get(&got, "SELECT stock FROM chair WHERE id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if got != stock-2 {
+		t.Errorf("unexpected stock. expected: %v, but got: %v", stock-2, got)
+	}
+}
@@ -0,0 +1,60 @@
+package cache
+
+import "sync"
+
+// Cache 1つの値を保持するキャッシュ
+// 値を読み込み中に無効化された場合に古い値を保存しないよう、世代番号で管理する
+type Cache struct {
+	mu    sync.RWMutex
+	value interface{}
+	ok    bool
+	gen   uint64
+}
+
+func New() *Cache {
+	return &Cache{}
+}
+
+// Get キャッシュされた値と現在の世代番号を返す
+// キャッシュされていない場合、呼び出し側は値を読み込み、返された世代番号とともに Set する
+func (c *Cache) Get() (value interface{}, gen uint64, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.value, c.gen, c.ok
+}
+
+// Set gen が現在の世代番号と一致する場合のみ値を保存する
+// Get から Set までの間に無効化されていれば保存せず false を返す
+func (c *Cache) Set(gen uint64, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return false
+	}
+	c.value = value
+	c.ok = true
+	return true
+}
+
+// Invalidate キャッシュを破棄し、読み込み中の値も保存されないようにする
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate()
+}
+
+// InvalidateIf キャッシュされた値に対して f が true を返した場合にキャッシュを破棄する
+// 値がキャッシュされていない場合は、読み込み中の値が古い可能性があるため常に無効化する
+func (c *Cache) InvalidateIf(f func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ok || f(c.value) {
+		c.invalidate()
+	}
+}
+
+func (c *Cache) invalidate() {
+	c.value = nil
+	c.ok = false
+	c.gen++
+}
@@ -0,0 +1,50 @@
+package cache
+
+import "testing"
+
+// Get で見つからず読み込んでいる間に無効化されたら、読み込んだ古い値は保存しない
+func TestCache_InvalidateBetweenGetAndSet(t *testing.T) {
+	c := New()
+
+	_, gen, ok := c.Get()
+	if ok {
+		t.Fatal("new cache should be empty")
+	}
+	c.Invalidate()
+	if c.Set(gen, "stale") {
+		t.Error("Set should fail after Invalidate")
+	}
+	if _, _, ok := c.Get(); ok {
+		t.Error("stale value should not be cached")
+	}
+
+	_, gen, _ = c.Get()
+	if !c.Set(gen, "fresh") {
+		t.Fatal("Set should succeed with the current generation")
+	}
+	if v, _, ok := c.Get(); !ok || v != "fresh" {
+		t.Errorf("Get() = %v, %v, want fresh, true", v, ok)
+	}
+}
+
+func TestCache_InvalidateIf(t *testing.T) {
+	c := New()
+
+	// キャッシュされていなければ、読み込み中の値が古いかもしれないので f によらず無効化する
+	_, gen, _ := c.Get()
+	c.InvalidateIf(func(interface{}) bool { return false })
+	if c.Set(gen, "stale") {
+		t.Error("Set should fail after InvalidateIf on an empty cache")
+	}
+
+	_, gen, _ = c.Get()
+	c.Set(gen, "cached")
+	c.InvalidateIf(func(interface{}) bool { return false })
+	if _, _, ok := c.Get(); !ok {
+		t.Error("value should be kept when f returns false")
+	}
+	c.InvalidateIf(func(interface{}) bool { return true })
+	if _, _, ok := c.Get(); ok {
+		t.Error("value should be dropped when f returns true")
+	}
+}
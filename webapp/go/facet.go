@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// searchFilter 検索条件の1つ
+// facet はその条件が絞り込む検索条件の項目名で、ファセットの対象でない条件は空になる
+type searchFilter struct {
+	facet  string
+	cond   string
+	params []interface{}
+}
+
+type searchFilters []searchFilter
+
+func (fs *searchFilters) add(facet, cond string, params ...interface{}) {
+	*fs = append(*fs, searchFilter{facet: facet, cond: cond, params: params})
+}
+
+// where except 以外の項目の条件を AND でつないだ WHERE 句とそのパラメータを返す
+func (fs searchFilters) where(except string) (string, []interface{}) {
+	conds := []string{}
+	params := []interface{}{}
+	for _, f := range fs {
+		if except != "" && f.facet == except {
+			continue
+		}
+		conds = append(conds, f.cond)
+		params = append(params, f.params...)
+	}
+	if len(conds) == 0 {
+		return "TRUE", params
+	}
+	return strings.Join(conds, " AND "), params
+}
+
+// facetColumn 値ごとの件数を数える検索条件の項目
+type facetColumn struct {
+	name   string
+	column string
+	keys   []string
+}
+
+func rangeFacet(name, column string, cond RangeCondition) facetColumn {
+	keys := make([]string, 0, len(cond.Ranges))
+	for _, r := range cond.Ranges {
+		keys = append(keys, strconv.FormatInt(r.ID, 10))
+	}
+	return facetColumn{name: name, column: column, keys: keys}
+}
+
+func listFacet(name, column string, cond ListCondition) facetColumn {
+	return facetColumn{name: name, column: column, keys: cond.List}
+}
+
+// SearchFacets 検索条件の項目名 -> 選択肢 (Range の ID または List の値) -> 件数
+// 範囲や色などの1つしか選べない項目は、その項目の条件を選択肢に置き換えたときの件数になる
+// 特徴は複数選べるため、現在の条件に選択肢を加えたときの件数になる
+type SearchFacets map[string]map[string]int64
+
+type facetCount struct {
+	Key   string `db:"k"`
+	Count int64  `db:"n"`
+}
+
+// countFacets 項目ごとに1回の GROUP BY で選択肢ごとの件数を数える
+func countFacets(table string, filters searchFilters, columns []facetColumn, feature ListCondition) (SearchFacets, error) {
+	facets := SearchFacets{}
+	for _, col := range columns {
+		counts := make(map[string]int64, len(col.keys))
+		for _, k := range col.keys {
+			counts[k] = 0
+		}
+
+		where, params := filters.where(col.name)
+		query := fmt.Sprintf("SELECT %[1]s AS k, COUNT(*) AS n FROM %[2]s WHERE %[3]s GROUP BY %[1]s", col.column, table, where)
+		rows := []facetCount{}
+		if err := db.Select(&rows, query, params...); err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			// 条件リストにない値 (範囲外の -1 など) は選択肢として表示されないため数えない
+			if _, ok := counts[r.Key]; ok {
+				counts[r.Key] = r.Count
+			}
+		}
+		facets[col.name] = counts
+	}
+
+	counts := make(map[string]int64, len(feature.List))
+	for _, f := range feature.List {
+		counts[f] = 0
+	}
+	where, params := filters.where("")
+	query := fmt.Sprintf("SELECT feature_id AS k, COUNT(*) AS n FROM %[1]s_feature WHERE %[1]s_id IN (SELECT id FROM %[1]s WHERE %[2]s) GROUP BY feature_id", table, where)
+	rows := []facetCount{}
+	if err := db.Select(&rows, query, params...); err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		id, err := strconv.Atoi(r.Key)
+		if err != nil || id < 0 || id >= len(feature.List) {
+			continue
+		}
+		counts[feature.List[id]] = r.Count
+	}
+	facets["feature"] = counts
+
+	return facets, nil
+}
+
+func chairFacetColumns() []facetColumn {
+	return []facetColumn{
+		rangeFacet("width", "width_range_id", chairSearchCondition.Width),
+		rangeFacet("height", "height_range_id", chairSearchCondition.Height),
+		rangeFacet("depth", "depth_range_id", chairSearchCondition.Depth),
+		rangeFacet("price", "price_range_id", chairSearchCondition.Price),
+		listFacet("color", "color", chairSearchCondition.Color),
+		listFacet("kind", "kind", chairSearchCondition.Kind),
+	}
+}
+
+func estateFacetColumns() []facetColumn {
+	return []facetColumn{
+		rangeFacet("doorWidth", "door_width_range_id", estateSearchCondition.DoorWidth),
+		rangeFacet("doorHeight", "door_height_range_id", estateSearchCondition.DoorHeight),
+		rangeFacet("rent", "rent_range_id", estateSearchCondition.Rent),
+	}
+}
+
+// facetsRequested facets パラメータが真なら true を返す
+func facetsRequested(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
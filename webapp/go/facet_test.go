@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchFilters_Where(t *testing.T) {
+	filters := searchFilters{}
+	filters.add("price", "price_range_id = ?", int64(1))
+	filters.add("color", "color = ?", "黒")
+	filters.add("", "stock > 0")
+
+	tests := []struct {
+		except string
+		cond   string
+		params []interface{}
+	}{
+		{except: "", cond: "price_range_id = ? AND color = ? AND stock > 0", params: []interface{}{int64(1), "黒"}},
+		{except: "price", cond: "color = ? AND stock > 0", params: []interface{}{"黒"}},
+		{except: "kind", cond: "price_range_id = ? AND color = ? AND stock > 0", params: []interface{}{int64(1), "黒"}},
+	}
+	for _, tt := range tests {
+		cond, params := filters.where(tt.except)
+		if cond != tt.cond || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("where(%q) = %q, %v, want %q, %v", tt.except, cond, params, tt.cond, tt.params)
+		}
+	}
+
+	only := searchFilters{}
+	only.add("rent", "rent_range_id = ?", int64(2))
+	if cond, params := only.where("rent"); cond != "TRUE" || len(params) != 0 {
+		t.Errorf("where without conditions = %q, %v", cond, params)
+	}
+}
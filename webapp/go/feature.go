@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// featureInsertBatchSize 特徴テーブルへの一括 INSERT 1回あたりの行数
+const featureInsertBatchSize = 1000
+
+// chairFeatureIDs, estateFeatureIDs 特徴名から特徴IDへの対応
+// 特徴IDは chair_condition.json, estate_condition.json の feature.list 内の位置
+var chairFeatureIDs map[string]int64
+var estateFeatureIDs map[string]int64
+
+func newFeatureIDs(cond ListCondition) map[string]int64 {
+	ids := make(map[string]int64, len(cond.List))
+	for i, f := range cond.List {
+		ids[f] = int64(i)
+	}
+	return ids
+}
+
+// splitFeatures カンマ区切りの特徴を重複と空要素を除いて分割する
+func splitFeatures(features string) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, f := range strings.Split(features, ",") {
+		if f == "" || seen[f] {
+			continue
+		}
+		seen[f] = true
+		res = append(res, f)
+	}
+	return res
+}
+
+// toFeatureIDs 特徴名を特徴IDに変換する
+// 条件リストにない特徴はどの行にも付与されないため無視する
+func toFeatureIDs(featureIDs map[string]int64, features []string) []int64 {
+	ids := make([]int64, 0, len(features))
+	for _, f := range features {
+		if id, ok := featureIDs[f]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// featureCondition 指定した特徴をすべて持つ行に絞り込む条件とそのパラメータを返す
+// 条件リストにない特徴が含まれる場合、件数が一致しないため結果は0件になる
+func featureCondition(table string, featureIDs map[string]int64, features []string) (string, []interface{}) {
+	ids := toFeatureIDs(featureIDs, features)
+	if len(ids) == 0 {
+		return "FALSE", nil
+	}
+
+	params := make([]interface{}, 0, len(ids)+1)
+	for _, id := range ids {
+		params = append(params, id)
+	}
+	params = append(params, len(features))
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	cond := fmt.Sprintf("id IN (SELECT %[1]s_id FROM %[1]s_feature WHERE feature_id IN (%[2]s) GROUP BY %[1]s_id HAVING COUNT(*) = ?)", table, placeholders)
+	return cond, params
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertFeatures table_feature に (id, 特徴ID) の組を一括で INSERT する
+func insertFeatures(ctx context.Context, ex execer, table string, rows [][2]int64) error {
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > featureInsertBatchSize {
+			n = featureInsertBatchSize
+		}
+
+		params := make([]interface{}, 0, n*2)
+		for _, r := range rows[:n] {
+			params = append(params, r[0], r[1])
+		}
+		query := fmt.Sprintf("INSERT INTO %[1]s_feature(%[1]s_id, feature_id) VALUES ", table) +
+			strings.TrimSuffix(strings.Repeat("(?,?),", n), ",")
+		if _, err := ex.ExecContext(ctx, query, params...); err != nil {
+			return err
+		}
+
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// featureRows 1行分の特徴を table_feature に INSERT する組に変換する
+func featureRows(featureIDs map[string]int64, id int64, features string) [][2]int64 {
+	ids := toFeatureIDs(featureIDs, splitFeatures(features))
+	rows := make([][2]int64, 0, len(ids))
+	for _, f := range ids {
+		rows = append(rows, [2]int64{id, f})
+	}
+	return rows
+}
+
+// loadFeatures 初期データの features カラムから table_feature を作る
+func loadFeatures(ctx context.Context, conn *sql.Conn, table string, featureIDs map[string]int64) (int, error) {
+	rs, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT id, features FROM %s WHERE features != ''", table))
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Close()
+
+	rows := [][2]int64{}
+	for rs.Next() {
+		var id int64
+		var features string
+		if err := rs.Scan(&id, &features); err != nil {
+			return 0, err
+		}
+		rows = append(rows, featureRows(featureIDs, id, features)...)
+	}
+	if err := rs.Err(); err != nil {
+		return 0, err
+	}
+	rs.Close()
+
+	return len(rows), insertFeatures(ctx, conn, table, rows)
+}
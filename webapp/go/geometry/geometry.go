@@ -0,0 +1,141 @@
+package geometry
+
+import "math"
+
+// Point 平面上の点
+// isuumo では MySQL の POINT(latitude longitude) と揃えて X に緯度、Y に経度を入れる
+type Point struct {
+	X float64
+	Y float64
+}
+
+// Polygon 穴のない単純多角形の外周
+// 始点と終点は一致していてもいなくてもよい
+type Polygon []Point
+
+// BoundingBox 外接矩形
+type BoundingBox struct {
+	Min Point
+	Max Point
+}
+
+// BoundingBox ポリゴンの外接矩形を返す
+func (p Polygon) BoundingBox() BoundingBox {
+	if len(p) == 0 {
+		return BoundingBox{}
+	}
+
+	b := BoundingBox{Min: p[0], Max: p[0]}
+	for _, pt := range p[1:] {
+		if pt.X < b.Min.X {
+			b.Min.X = pt.X
+		}
+		if pt.Y < b.Min.Y {
+			b.Min.Y = pt.Y
+		}
+		if pt.X > b.Max.X {
+			b.Max.X = pt.X
+		}
+		if pt.Y > b.Max.Y {
+			b.Max.Y = pt.Y
+		}
+	}
+	return b
+}
+
+// Contains 外接矩形の内部または境界上に点があるかを返す
+func (b BoundingBox) Contains(pt Point) bool {
+	return b.Min.X <= pt.X && pt.X <= b.Max.X && b.Min.Y <= pt.Y && pt.Y <= b.Max.Y
+}
+
+// Contains 点がポリゴンの内部にあるかを返す
+// MySQL の ST_Contains と同様に、辺や頂点の上にある点は含まない
+func (p Polygon) Contains(pt Point) bool {
+	if len(p) < 3 {
+		return false
+	}
+
+	if p.OnBoundary(pt) {
+		return false
+	}
+
+	// 点から X 軸の正方向に伸ばした半直線と辺の交差回数の偶奇で判定する (ray casting)
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.Y > pt.Y) == (b.Y > pt.Y) {
+			continue
+		}
+		x := a.X + (pt.Y-a.Y)*(b.X-a.X)/(b.Y-a.Y)
+		if pt.X < x {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// OnBoundary 点がポリゴンのいずれかの辺の上にあるかを返す
+func (p Polygon) OnBoundary(pt Point) bool {
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		if onSegment(p[j], p[i], pt) {
+			return true
+		}
+	}
+	return false
+}
+
+func onSegment(a, b, pt Point) bool {
+	cross := (b.X-a.X)*(pt.Y-a.Y) - (b.Y-a.Y)*(pt.X-a.X)
+	if cross != 0 {
+		return false
+	}
+	return min(a.X, b.X) <= pt.X && pt.X <= max(a.X, b.X) &&
+		min(a.Y, b.Y) <= pt.Y && pt.Y <= max(a.Y, b.Y)
+}
+
+func min(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// EarthRadius 地球の平均半径 (km)
+const EarthRadius = 6371.0088
+
+// Distance 2点間の大円距離 (km) を haversine 公式で求める
+// 点の X は緯度、Y は経度 (度)
+func Distance(a, b Point) float64 {
+	lat1, lat2 := a.X*math.Pi/180, b.X*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Y - a.Y) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// BoundingBoxAround 中心から radius (km) 以内の点をすべて含む外接矩形を返す
+// 経度方向の幅は緯度が高いほど広がるため、範囲内で極に最も近い緯度で計算する
+// 経度 ±180 度をまたぐ範囲は考慮しない
+func BoundingBoxAround(center Point, radius float64) BoundingBox {
+	dLat := radius / EarthRadius * 180 / math.Pi
+	b := BoundingBox{
+		Min: Point{X: math.Max(-90, center.X-dLat)},
+		Max: Point{X: math.Min(90, center.X+dLat)},
+	}
+
+	maxLat := math.Max(math.Abs(b.Min.X), math.Abs(b.Max.X))
+	if maxLat >= 90 {
+		b.Min.Y, b.Max.Y = -180, 180
+		return b
+	}
+	dLng := dLat / math.Cos(maxLat*math.Pi/180)
+	b.Min.Y, b.Max.Y = center.Y-dLng, center.Y+dLng
+	return b
+}
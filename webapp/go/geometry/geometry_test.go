@@ -0,0 +1,221 @@
+package geometry
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestPolygon_Contains(t *testing.T) {
+	square := Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	// 凹型 (コの字型) のポリゴン
+	concave := Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 7}, {3, 7}, {3, 3}, {10, 3}, {10, 0}}
+
+	tests := []struct {
+		name    string
+		polygon Polygon
+		point   Point
+		want    bool
+	}{
+		{name: "inside", polygon: square, point: Point{5, 5}, want: true},
+		{name: "outside", polygon: square, point: Point{11, 5}, want: false},
+		{name: "on edge", polygon: square, point: Point{0, 5}, want: false},
+		{name: "on vertex", polygon: square, point: Point{10, 10}, want: false},
+		{name: "ray through vertex", polygon: square, point: Point{-1, 10}, want: false},
+		{name: "unclosed ring", polygon: square[:4], point: Point{5, 5}, want: true},
+		{name: "concave inside", polygon: concave, point: Point{1, 5}, want: true},
+		{name: "concave notch", polygon: concave, point: Point{5, 5}, want: false},
+		{name: "concave arm", polygon: concave, point: Point{8, 8}, want: true},
+		{name: "concave notch edge", polygon: concave, point: Point{3, 5}, want: false},
+		{name: "degenerate", polygon: square[:2], point: Point{0, 5}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.polygon.Contains(tt.point); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.point, got, tt.want)
+			}
+		})
+	}
+}
+
+// testdata 以下には、なぞって検索の検証用データと同じ形式のスナップショットと物件を小さく切り出して置いている
+// 凸多角形、凹多角形、件数上限を超える範囲を含む
+const testdataDir = "testdata"
+
+const nazotteLimit = 50
+
+type estate struct {
+	ID         int64   `json:"id"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Popularity int64   `json:"popularity"`
+}
+
+type snapshot struct {
+	Request struct {
+		Body string `json:"body"`
+	} `json:"request"`
+	Response struct {
+		Body string `json:"body"`
+	} `json:"response"`
+}
+
+func loadEstates(t *testing.T) []estate {
+	f, err := os.Open(filepath.Join(testdataDir, "estate_json.txt"))
+	if err != nil {
+		t.Fatal("failed to open estates:", err)
+	}
+	defer f.Close()
+
+	estates := []estate{}
+	decoder := json.NewDecoder(f)
+	for {
+		var e estate
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal("failed to decode estate:", err)
+		}
+		estates = append(estates, e)
+	}
+
+	sort.Slice(estates, func(i, j int) bool {
+		if estates[i].Popularity == estates[j].Popularity {
+			return estates[i].ID < estates[j].ID
+		}
+		return estates[i].Popularity > estates[j].Popularity
+	})
+	return estates
+}
+
+func TestPolygon_ContainsWithNazotteSnapshot(t *testing.T) {
+	estates := loadEstates(t)
+
+	snapshotsDir := filepath.Join(testdataDir, "estate_nazotte")
+	files, err := ioutil.ReadDir(snapshotsDir)
+	if err != nil {
+		t.Fatal("failed to read snapshots:", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no snapshot found in", snapshotsDir)
+	}
+
+	for _, file := range files {
+		file := file
+		t.Run(file.Name(), func(t *testing.T) {
+			raw, err := ioutil.ReadFile(filepath.Join(snapshotsDir, file.Name()))
+			if err != nil {
+				t.Fatal("failed to read snapshot:", err)
+			}
+			var s snapshot
+			if err := json.Unmarshal(raw, &s); err != nil {
+				t.Fatal("failed to unmarshal snapshot:", err)
+			}
+
+			var req struct {
+				Coordinates []struct {
+					Latitude  float64 `json:"latitude"`
+					Longitude float64 `json:"longitude"`
+				} `json:"coordinates"`
+			}
+			if err := json.Unmarshal([]byte(s.Request.Body), &req); err != nil {
+				t.Fatal("failed to unmarshal request body:", err)
+			}
+			var res struct {
+				Count   int64    `json:"count"`
+				Estates []estate `json:"estates"`
+			}
+			if err := json.Unmarshal([]byte(s.Response.Body), &res); err != nil {
+				t.Fatal("failed to unmarshal response body:", err)
+			}
+
+			polygon := make(Polygon, 0, len(req.Coordinates))
+			for _, c := range req.Coordinates {
+				polygon = append(polygon, Point{X: c.Latitude, Y: c.Longitude})
+			}
+
+			got := []int64{}
+			for _, e := range estates {
+				if len(got) >= nazotteLimit {
+					break
+				}
+				if polygon.Contains(Point{X: e.Latitude, Y: e.Longitude}) {
+					got = append(got, e.ID)
+				}
+			}
+
+			// count は上限件数で打ち切った後の件数
+			if res.Count != int64(len(got)) {
+				t.Errorf("unexpected count. expected: %v, but got: %v", res.Count, len(got))
+			}
+			if len(got) != len(res.Estates) {
+				t.Fatalf("unexpected number of estates. expected: %v, but got: %v", len(res.Estates), len(got))
+			}
+			for i, e := range res.Estates {
+				if got[i] != e.ID {
+					t.Errorf("unexpected estate at %d. expected: %v, but got: %v", i, e.ID, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tokyo := Point{35.681236, 139.767125}
+	shinjuku := Point{35.690921, 139.700258}
+	osaka := Point{34.702485, 135.495951}
+
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{name: "same point", a: tokyo, b: tokyo, want: 0},
+		{name: "tokyo-shinjuku", a: tokyo, b: shinjuku, want: 6.13},
+		{name: "tokyo-osaka", a: tokyo, b: osaka, want: 403.5},
+		{name: "one degree of latitude", a: Point{0, 0}, b: Point{1, 0}, want: 111.19},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Distance(tt.a, tt.b)
+			if math.Abs(got-tt.want) > tt.want*0.005+0.001 {
+				t.Errorf("Distance() = %v, want %v", got, tt.want)
+			}
+			if back := Distance(tt.b, tt.a); math.Abs(back-got) > 1e-9 {
+				t.Errorf("Distance() is not symmetric: %v, %v", got, back)
+			}
+		})
+	}
+}
+
+func TestBoundingBoxAround(t *testing.T) {
+	center := Point{35.681236, 139.767125}
+	radius := 10.0
+	b := BoundingBoxAround(center, radius)
+
+	// 円周上の点はすべて外接矩形に含まれる
+	for deg := 0; deg < 360; deg += 5 {
+		rad := float64(deg) * math.Pi / 180
+		// 外接矩形の辺の上にあるかの判定が丸め誤差で揺れないよう、少しだけ内側の点を使う
+		r := radius * 0.999
+		lat := center.X + r/EarthRadius*180/math.Pi*math.Cos(rad)
+		lng := center.Y + r/EarthRadius*180/math.Pi*math.Sin(rad)/math.Cos(lat*math.Pi/180)
+		pt := Point{lat, lng}
+		if d := Distance(center, pt); d > radius {
+			continue
+		}
+		if !b.Contains(pt) {
+			t.Errorf("point %v at %v degrees is outside of %v", pt, deg, b)
+		}
+	}
+
+	if polar := BoundingBoxAround(Point{89.99, 0}, radius); polar.Min.Y != -180 || polar.Max.Y != 180 || polar.Max.X != 90 {
+		t.Errorf("bounding box around the pole should cover all longitudes: %v", polar)
+	}
+}
@@ -0,0 +1,208 @@
+package geometry
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PolygonWithHoles 外周と、その内側にくり抜く穴からなる多角形
+type PolygonWithHoles struct {
+	Outer Polygon
+	Holes []Polygon
+}
+
+// MultiPolygon 互いに重ならない複数の多角形
+type MultiPolygon []PolygonWithHoles
+
+// Close 連続する重複点を取り除き、始点と終点が一致するように閉じたリングを返す
+func (p Polygon) Close() Polygon {
+	ring := make(Polygon, 0, len(p)+1)
+	for _, pt := range p {
+		if len(ring) > 0 && ring[len(ring)-1] == pt {
+			continue
+		}
+		ring = append(ring, pt)
+	}
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+	return ring
+}
+
+// segments 閉じたリングの辺の数
+func (p Polygon) segments() int {
+	return len(p) - 1
+}
+
+func (p Polygon) segment(i int) (Point, Point) {
+	return p[i], p[i+1]
+}
+
+// Validate 閉じたリングが3つ以上の頂点を持ち、面積があり、自己交差しないことを確かめる
+func (p Polygon) Validate() error {
+	if len(p) < 4 || p[0] != p[len(p)-1] {
+		return errors.New("ring must have at least 3 distinct points")
+	}
+	n := p.segments()
+	for i := 0; i < n; i++ {
+		a1, a2 := p.segment(i)
+		for j := i + 1; j < n; j++ {
+			b1, b2 := p.segment(j)
+			adjacent := j == i+1 || (i == 0 && j == n-1)
+			if !adjacent {
+				if segmentsIntersect(a1, a2, b1, b2) {
+					return fmt.Errorf("ring is self-intersecting at edges %d and %d", i, j)
+				}
+				continue
+			}
+			// 隣り合う辺は頂点を共有するので、それ以外で重なる (折り返す) 場合だけを交差とみなす
+			shared, other := a2, b2
+			if i == 0 && j == n-1 {
+				shared, other = a1, b1
+			}
+			far := a1
+			if shared == a1 {
+				far = a2
+			}
+			if onSegment(shared, far, other) || onSegment(shared, other, far) {
+				return fmt.Errorf("ring is self-intersecting at edges %d and %d", i, j)
+			}
+		}
+	}
+	if p.area() == 0 {
+		return errors.New("ring has no area")
+	}
+	return nil
+}
+
+// area 符号付き面積の2倍 (shoelace formula)
+func (p Polygon) area() float64 {
+	s := 0.0
+	for i := 0; i < p.segments(); i++ {
+		a, b := p.segment(i)
+		s += a.X*b.Y - b.X*a.Y
+	}
+	return s
+}
+
+// ringsIntersect 2つの閉じたリングの辺が交差または接するかを返す
+func ringsIntersect(p, q Polygon) bool {
+	for i := 0; i < p.segments(); i++ {
+		a1, a2 := p.segment(i)
+		for j := 0; j < q.segments(); j++ {
+			b1, b2 := q.segment(j)
+			if segmentsIntersect(a1, a2, b1, b2) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// segmentsIntersect 線分 a1-a2 と b1-b2 が交差または接するかを返す
+func segmentsIntersect(a1, a2, b1, b2 Point) bool {
+	d1 := orientation(b1, b2, a1)
+	d2 := orientation(b1, b2, a2)
+	d3 := orientation(a1, a2, b1)
+	d4 := orientation(a1, a2, b2)
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return onSegment(b1, b2, a1) || onSegment(b1, b2, a2) || onSegment(a1, a2, b1) || onSegment(a1, a2, b2)
+}
+
+func orientation(a, b, c Point) float64 {
+	return (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+}
+
+// Validate 外周と穴がそれぞれ正しいリングで、穴が外周の内側に接することなく収まり、穴同士が重ならないことを確かめる
+func (p PolygonWithHoles) Validate() error {
+	if err := p.Outer.Validate(); err != nil {
+		return fmt.Errorf("outer %v", err)
+	}
+	for i, h := range p.Holes {
+		if err := h.Validate(); err != nil {
+			return fmt.Errorf("hole %d: %v", i, err)
+		}
+		if ringsIntersect(p.Outer, h) || !p.Outer.Contains(h[0]) {
+			return fmt.Errorf("hole %d is not inside the outer ring", i)
+		}
+		for j, other := range p.Holes[:i] {
+			if ringsIntersect(h, other) || other.Contains(h[0]) || h.Contains(other[0]) {
+				return fmt.Errorf("holes %d and %d overlap", j, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Contains 点が外周の内部にあり、どの穴の内部や境界上にもないかを返す
+func (p PolygonWithHoles) Contains(pt Point) bool {
+	if !p.Outer.Contains(pt) {
+		return false
+	}
+	for _, h := range p.Holes {
+		if h.Contains(pt) || h.OnBoundary(pt) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate 各多角形が正しく、多角形同士が重ならないことを確かめる
+// 他の多角形の穴の中に収まる多角形は重ならないものとして扱う
+func (m MultiPolygon) Validate() error {
+	if len(m) == 0 {
+		return errors.New("no polygon")
+	}
+	for i, p := range m {
+		if err := p.Validate(); err != nil {
+			if len(m) == 1 {
+				return err
+			}
+			return fmt.Errorf("polygon %d: %v", i, err)
+		}
+		for j, other := range m[:i] {
+			if polygonsOverlap(p, other) {
+				return fmt.Errorf("polygons %d and %d overlap", j, i)
+			}
+		}
+	}
+	return nil
+}
+
+// polygonsOverlap 2つの多角形の辺が交差または接するか、一方の内部にもう一方があるかを返す
+func polygonsOverlap(p, q PolygonWithHoles) bool {
+	for _, a := range append([]Polygon{p.Outer}, p.Holes...) {
+		for _, b := range append([]Polygon{q.Outer}, q.Holes...) {
+			if ringsIntersect(a, b) {
+				return true
+			}
+		}
+	}
+	return p.Contains(q.Outer[0]) || q.Contains(p.Outer[0])
+}
+
+// Contains 点がいずれかの多角形に含まれるかを返す
+func (m MultiPolygon) Contains(pt Point) bool {
+	for _, p := range m {
+		if p.Contains(pt) {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundingBox すべての外周を囲む外接矩形を返す
+func (m MultiPolygon) BoundingBox() BoundingBox {
+	if len(m) == 0 {
+		return BoundingBox{}
+	}
+	b := m[0].Outer.BoundingBox()
+	for _, p := range m[1:] {
+		o := p.Outer.BoundingBox()
+		b.Min.X, b.Min.Y = min(b.Min.X, o.Min.X), min(b.Min.Y, o.Min.Y)
+		b.Max.X, b.Max.Y = max(b.Max.X, o.Max.X), max(b.Max.Y, o.Max.Y)
+	}
+	return b
+}
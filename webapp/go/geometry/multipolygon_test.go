@@ -0,0 +1,108 @@
+package geometry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPolygon_Close(t *testing.T) {
+	open := Polygon{{0, 0}, {0, 10}, {0, 10}, {10, 10}}
+	want := Polygon{{0, 0}, {0, 10}, {10, 10}, {0, 0}}
+	if got := open.Close(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Close() = %v, want %v", got, want)
+	}
+	if got := want.Close(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Close() of a closed ring = %v, want %v", got, want)
+	}
+}
+
+func TestPolygon_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		ring  Polygon
+		valid bool
+	}{
+		{name: "square", ring: Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 0}}, valid: true},
+		{name: "triangle", ring: Polygon{{0, 0}, {0, 10}, {10, 0}}, valid: true},
+		{name: "concave", ring: Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 7}, {3, 7}, {3, 3}, {10, 3}, {10, 0}}, valid: true},
+		{name: "too few points", ring: Polygon{{0, 0}, {0, 10}}, valid: false},
+		{name: "collinear", ring: Polygon{{0, 0}, {0, 5}, {0, 10}}, valid: false},
+		{name: "bow tie", ring: Polygon{{0, 0}, {10, 10}, {10, 0}, {0, 10}}, valid: false},
+		{name: "touching vertex", ring: Polygon{{0, 0}, {0, 10}, {5, 5}, {10, 10}, {10, 0}, {5, 5}}, valid: false},
+		{name: "spike", ring: Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {15, 0}, {5, 0}}, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ring.Close().Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid: %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMultiPolygon_Validate(t *testing.T) {
+	outer := Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}
+	hole := Polygon{{4, 4}, {4, 6}, {6, 6}, {6, 4}, {4, 4}}
+
+	tests := []struct {
+		name  string
+		m     MultiPolygon
+		valid bool
+	}{
+		{name: "with hole", m: MultiPolygon{{Outer: outer, Holes: []Polygon{hole}}}, valid: true},
+		{name: "two polygons", m: MultiPolygon{{Outer: outer}, {Outer: Polygon{{20, 20}, {20, 30}, {30, 30}, {20, 20}}}}, valid: true},
+		{name: "polygon in hole", m: MultiPolygon{{Outer: outer, Holes: []Polygon{{{2, 2}, {2, 8}, {8, 8}, {8, 2}, {2, 2}}}}, {Outer: hole}}, valid: true},
+		{name: "empty", m: MultiPolygon{}, valid: false},
+		{name: "hole outside", m: MultiPolygon{{Outer: outer, Holes: []Polygon{{{20, 20}, {20, 30}, {30, 30}, {20, 20}}}}}, valid: false},
+		{name: "hole crossing outer", m: MultiPolygon{{Outer: outer, Holes: []Polygon{{{5, 5}, {5, 15}, {15, 15}, {5, 5}}}}}, valid: false},
+		{name: "hole touching outer", m: MultiPolygon{{Outer: outer, Holes: []Polygon{{{0, 5}, {5, 8}, {5, 2}, {0, 5}}}}}, valid: false},
+		{name: "overlapping holes", m: MultiPolygon{{Outer: outer, Holes: []Polygon{hole, {{5, 5}, {5, 8}, {8, 8}, {8, 5}, {5, 5}}}}}, valid: false},
+		{name: "nested holes", m: MultiPolygon{{Outer: outer, Holes: []Polygon{{{2, 2}, {2, 8}, {8, 8}, {8, 2}, {2, 2}}, hole}}}, valid: false},
+		{name: "overlapping polygons", m: MultiPolygon{{Outer: outer}, {Outer: Polygon{{5, 5}, {5, 15}, {15, 15}, {5, 5}}}}, valid: false},
+		{name: "polygon inside polygon", m: MultiPolygon{{Outer: outer}, {Outer: hole}}, valid: false},
+		{name: "polygon crossing hole", m: MultiPolygon{{Outer: outer, Holes: []Polygon{hole}}, {Outer: Polygon{{5, 5}, {5, 7}, {7, 7}, {7, 5}, {5, 5}}}}, valid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid: %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestMultiPolygon_Contains(t *testing.T) {
+	m := MultiPolygon{
+		{
+			Outer: Polygon{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}},
+			Holes: []Polygon{{{4, 4}, {4, 6}, {6, 6}, {6, 4}, {4, 4}}},
+		},
+		{Outer: Polygon{{20, 20}, {20, 30}, {30, 30}, {30, 20}, {20, 20}}},
+	}
+
+	tests := []struct {
+		name  string
+		point Point
+		want  bool
+	}{
+		{name: "inside first", point: Point{2, 2}, want: true},
+		{name: "inside hole", point: Point{5, 5}, want: false},
+		{name: "on hole edge", point: Point{4, 5}, want: false},
+		{name: "inside second", point: Point{25, 25}, want: true},
+		{name: "between polygons", point: Point{15, 15}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Contains(tt.point); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.point, got, tt.want)
+			}
+		})
+	}
+
+	b := m.BoundingBox()
+	if b.Min != (Point{0, 0}) || b.Max != (Point{30, 30}) {
+		t.Errorf("unexpected bounding box: %v", b)
+	}
+}
@@ -0,0 +1,30 @@
+#!/bin/bash
+# estate_nazotte のスナップショットを MySQL で判定するなぞって検索の結果から作り直す
+# estate テーブルの中身を estate_json.txt の物件に置き換えるので、使い捨ての DB に対して実行すること
+# NAZOTTE_EVALUATOR=mysql (デフォルト) で起動した webapp に ISUUMO_URL でリクエストする
+set -xe
+set -o pipefail
+
+CURRENT_DIR=$(cd $(dirname $0);pwd)
+export MYSQL_HOST=${MYSQL_HOST:-127.0.0.1}
+export MYSQL_PORT=${MYSQL_PORT:-3306}
+export MYSQL_USER=${MYSQL_USER:-isucon}
+export MYSQL_DBNAME=${MYSQL_DBNAME:-isuumo}
+export MYSQL_PWD=${MYSQL_PASS:-isucon}
+export LANG="C.UTF-8"
+ISUUMO_URL=${ISUUMO_URL:-http://127.0.0.1:1323}
+cd $CURRENT_DIR
+
+(
+  echo "DELETE FROM estate_feature; DELETE FROM estate;"
+  jq -r '"INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(\(.id), \"test\", \"test\", \"/images/estate/test.png\", \"東京都\", \(.latitude), \(.longitude), 100000, 100, 100, \"\", \(.popularity));"' estate_json.txt
+) | mysql --defaults-file=/dev/null -h $MYSQL_HOST -P $MYSQL_PORT -u $MYSQL_USER $MYSQL_DBNAME
+
+# リクエストはそのまま使い、レスポンスは検証に使う count と物件の id, latitude, longitude だけを残す
+for f in estate_nazotte/*.json; do
+  req=$(jq -r .request.body $f)
+  res=$(curl -sS -f -X POST -H "Content-Type: application/json" -d "$req" "$ISUUMO_URL/api/estate/nazotte" \
+    | jq -c '{count, estates: [.estates[] | {id, latitude, longitude}]}')
+  jq -c --arg body "$res" '.response = {statusCode: 200, body: $body}' $f > $f.tmp
+  mv $f.tmp $f
+done
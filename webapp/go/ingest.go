@@ -0,0 +1,621 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo"
+)
+
+// ingestBatchSize 一括 INSERT 1回あたりの行数
+const ingestBatchSize = 500
+
+// IngestError 登録しなかった行とその理由
+// Line は CSV のレコード、JSON の配列の要素、NDJSON の行の番号 (1始まり)
+type IngestError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+func (e *IngestError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// IngestReport 一括登録の結果
+type IngestReport struct {
+	Inserted int           `json:"inserted"`
+	Updated  int           `json:"updated"`
+	Skipped  int           `json:"skipped"`
+	Rejected []IngestError `json:"rejected"`
+}
+
+const (
+	onConflictError  = "error"
+	onConflictUpdate = "update"
+	onConflictSkip   = "skip"
+)
+
+// ingestOption 一括登録のオプション
+// partial が true なら不正な行を飛ばして残りを登録する
+// onConflict は既に登録済みの id の行を、エラーにする (error)、上書きする (update)、飛ばす (skip) のいずれか
+type ingestOption struct {
+	partial    bool
+	onConflict string
+}
+
+// ingestTable 一括登録するテーブルの定義
+// stockColumn は予約中の分を差し引いて保存する在庫のカラムで、予約のないテーブルでは空
+type ingestTable struct {
+	name        string
+	columns     []string
+	featureIDs  map[string]int64
+	stockColumn string
+}
+
+func chairIngestTable() ingestTable {
+	return ingestTable{
+		name:        "chair",
+		columns:     []string{"id", "name", "description", "thumbnail", "price", "height", "width", "depth", "color", "features", "kind", "popularity", "stock", "price_range_id", "height_range_id", "width_range_id", "depth_range_id"},
+		featureIDs:  chairFeatureIDs,
+		stockColumn: "stock",
+	}
+}
+
+func estateIngestTable() ingestTable {
+	return ingestTable{
+		name:       "estate",
+		columns:    []string{"id", "name", "description", "thumbnail", "address", "latitude", "longitude", "rent", "door_height", "door_width", "features", "popularity", "rent_range_id", "door_height_range_id", "door_width_range_id"},
+		featureIDs: estateFeatureIDs,
+	}
+}
+
+// ingestRow 一括登録する1行分の値
+type ingestRow struct {
+	line     int
+	id       int64
+	values   []interface{}
+	features string
+}
+
+func chairIngestRow(line int, chair Chair) ingestRow {
+	return ingestRow{
+		line: line,
+		id:   chair.ID,
+		values: []interface{}{
+			chair.ID, chair.Name, chair.Description, chair.Thumbnail, chair.Price, chair.Height, chair.Width, chair.Depth,
+			chair.Color, chair.Features, chair.Kind, chair.Popularity, chair.Stock,
+			getRangeID(chairSearchCondition.Price, chair.Price),
+			getRangeID(chairSearchCondition.Height, chair.Height),
+			getRangeID(chairSearchCondition.Width, chair.Width),
+			getRangeID(chairSearchCondition.Depth, chair.Depth),
+		},
+		features: chair.Features,
+	}
+}
+
+func estateIngestRow(line int, estate Estate) ingestRow {
+	return ingestRow{
+		line: line,
+		id:   estate.ID,
+		values: []interface{}{
+			estate.ID, estate.Name, estate.Description, estate.Thumbnail, estate.Address, estate.Latitude, estate.Longitude,
+			estate.Rent, estate.DoorHeight, estate.DoorWidth, estate.Features, estate.Popularity,
+			getRangeID(estateSearchCondition.Rent, estate.Rent),
+			getRangeID(estateSearchCondition.DoorHeight, estate.DoorHeight),
+			getRangeID(estateSearchCondition.DoorWidth, estate.DoorWidth),
+		},
+		features: estate.Features,
+	}
+}
+
+// ingester 検証済みの行を溜めて複数行の INSERT でまとめて登録する
+// partial でない場合、1行でも不正な行があればその時点でエラーを返す
+type ingester struct {
+	ctx   context.Context
+	tx    *sqlx.Tx
+	table ingestTable
+	ingestOption
+
+	seen   map[int64]bool
+	batch  []ingestRow
+	report IngestReport
+}
+
+func newIngester(ctx context.Context, tx *sqlx.Tx, table ingestTable, opt ingestOption) *ingester {
+	return &ingester{
+		ctx:          ctx,
+		tx:           tx,
+		table:        table,
+		ingestOption: opt,
+		seen:         map[int64]bool{},
+		batch:        make([]ingestRow, 0, ingestBatchSize),
+		report:       IngestReport{Rejected: []IngestError{}},
+	}
+}
+
+// Reject 行を登録しない。partial でなければ *IngestError を返す
+func (in *ingester) Reject(line int, reason string) error {
+	e := IngestError{Line: line, Reason: reason}
+	in.report.Rejected = append(in.report.Rejected, e)
+	if !in.partial {
+		return &e
+	}
+	return nil
+}
+
+// Add 行を登録する。溜まった行が ingestBatchSize に達したら INSERT する
+func (in *ingester) Add(row ingestRow) error {
+	if in.seen[row.id] {
+		return in.Reject(row.line, fmt.Sprintf("duplicated id %d", row.id))
+	}
+	in.seen[row.id] = true
+
+	in.batch = append(in.batch, row)
+	if len(in.batch) >= ingestBatchSize {
+		return in.Flush()
+	}
+	return nil
+}
+
+// Flush 溜まっている行を INSERT する
+// 登録済みの id の行は onConflict に従って扱う
+func (in *ingester) Flush() error {
+	if len(in.batch) == 0 {
+		return nil
+	}
+	rows := in.batch
+	in.batch = in.batch[:0]
+
+	existing, err := in.existingIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	reserved := map[int64]int64{}
+	if in.onConflict == onConflictUpdate && in.table.stockColumn != "" && len(existing) > 0 {
+		reserved, err = in.reservedCounts(existing)
+		if err != nil {
+			return err
+		}
+	}
+
+	targets := make([]ingestRow, 0, len(rows))
+	updated := []int64{}
+	for _, row := range rows {
+		if !existing[row.id] {
+			targets = append(targets, row)
+			continue
+		}
+		switch in.onConflict {
+		case onConflictUpdate:
+			// chair.stock は予約中の分を差し引いた在庫なので、PUT /api/chair/:id と同じく予約数を差し引いて上書きする
+			if n := reserved[row.id]; n > 0 {
+				i := in.table.columnIndex(in.table.stockColumn)
+				stock := row.values[i].(int64)
+				if stock < n {
+					if err := in.Reject(row.line, fmt.Sprintf("stock %d is less than %d reserved", stock, n)); err != nil {
+						return err
+					}
+					continue
+				}
+				row.values[i] = stock - n
+			}
+			targets = append(targets, row)
+			updated = append(updated, row.id)
+		case onConflictSkip:
+			in.report.Skipped++
+		default:
+			if err := in.Reject(row.line, fmt.Sprintf("id %d already exists", row.id)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	if err := in.insert(targets, len(updated) > 0); err != nil {
+		return err
+	}
+	in.report.Inserted += len(targets) - len(updated)
+	in.report.Updated += len(updated)
+	return nil
+}
+
+// existingIDs rows のうち既に登録済みの id を返す
+// 他の一括登録が同じ id を並行して登録しないよう、行ロック (存在しない id はギャップロック) を取る
+func (in *ingester) existingIDs(rows []ingestRow) (map[int64]bool, error) {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.id)
+	}
+	query, params, err := sqlx.In(fmt.Sprintf("SELECT id FROM %s WHERE id IN (?) FOR UPDATE", in.table.name), ids)
+	if err != nil {
+		return nil, err
+	}
+	var found []int64
+	if err := in.tx.SelectContext(in.ctx, &found, query, params...); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[int64]bool, len(found))
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// reservedCounts 登録済みの id ごとの予約中の在庫数を返す
+// 予約と購入はイスの行ロックを取ってから予約を操作するので、existingIDs でロックしている間は変わらない
+func (in *ingester) reservedCounts(existing map[int64]bool) (map[int64]int64, error) {
+	ids := make([]int64, 0, len(existing))
+	for id := range existing {
+		ids = append(ids, id)
+	}
+	query, params, err := sqlx.In("SELECT chair_id, COUNT(*) AS count FROM chair_reservation WHERE chair_id IN (?) GROUP BY chair_id", ids)
+	if err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		ChairID int64 `db:"chair_id"`
+		Count   int64 `db:"count"`
+	}
+	if err := in.tx.SelectContext(in.ctx, &counts, query, params...); err != nil {
+		return nil, err
+	}
+
+	reserved := make(map[int64]int64, len(counts))
+	for _, c := range counts {
+		reserved[c.ChairID] = c.Count
+	}
+	return reserved, nil
+}
+
+func (t ingestTable) columnIndex(name string) int {
+	for i, col := range t.columns {
+		if col == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// insert rows を1つの INSERT で登録する
+// upsert なら登録済みの行を上書きし、特徴も作り直す
+func (in *ingester) insert(rows []ingestRow, upsert bool) error {
+	placeholder := "(" + strings.TrimSuffix(strings.Repeat("?,", len(in.table.columns)), ",") + ")"
+	query := fmt.Sprintf("INSERT INTO %s(%s) VALUES ", in.table.name, strings.Join(in.table.columns, ", ")) +
+		strings.TrimSuffix(strings.Repeat(placeholder+",", len(rows)), ",")
+	if upsert {
+		assignments := make([]string, 0, len(in.table.columns))
+		for _, col := range in.table.columns[1:] {
+			assignments = append(assignments, fmt.Sprintf("%[1]s = VALUES(%[1]s)", col))
+		}
+		assignments = append(assignments, "version = version + 1")
+		query += " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+
+	params := make([]interface{}, 0, len(rows)*len(in.table.columns))
+	ids := make([]int64, 0, len(rows))
+	features := [][2]int64{}
+	for _, row := range rows {
+		params = append(params, row.values...)
+		ids = append(ids, row.id)
+		features = append(features, featureRows(in.table.featureIDs, row.id, row.features)...)
+	}
+
+	if _, err := in.tx.ExecContext(in.ctx, query, params...); err != nil {
+		return err
+	}
+	if upsert {
+		q, p, err := sqlx.In(fmt.Sprintf("DELETE FROM %[1]s_feature WHERE %[1]s_id IN (?)", in.table.name), ids)
+		if err != nil {
+			return err
+		}
+		if _, err := in.tx.ExecContext(in.ctx, q, p...); err != nil {
+			return err
+		}
+	}
+	return insertFeatures(in.ctx, in.tx, in.table.name, features)
+}
+
+// ingestOptions mode, onConflict クエリパラメータから一括登録のオプションを返す
+func ingestOptions(c echo.Context) (ingestOption, bool) {
+	opt := ingestOption{onConflict: onConflictError}
+
+	switch c.QueryParam("mode") {
+	case "", "strict":
+	case "partial":
+		opt.partial = true
+	default:
+		return opt, false
+	}
+
+	switch v := c.QueryParam("onConflict"); v {
+	case "":
+	case onConflictError, onConflictUpdate, onConflictSkip:
+		opt.onConflict = v
+	default:
+		return opt, false
+	}
+	return opt, true
+}
+
+const (
+	uploadFormatCSV    = "csv"
+	uploadFormatJSON   = "json"
+	uploadFormatNDJSON = "ndjson"
+)
+
+// uploadFormat Content-Type から一括登録のデータの形式を返す
+// JSON, NDJSON はリクエストボディに、CSV は multipart/form-data のファイルとして送られてくる
+func uploadFormat(c echo.Context) string {
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	switch mediaType {
+	case echo.MIMEApplicationJSON:
+		return uploadFormatJSON
+	case "application/x-ndjson":
+		return uploadFormatNDJSON
+	}
+	return uploadFormatCSV
+}
+
+// errUploadPartNotFound multipart/form-data に指定した名前のファイルがない
+var errUploadPartNotFound = errors.New("upload part not found")
+
+// uploadPart multipart/form-data から name のファイルを探して返す
+// ファイル全体をメモリや一時ファイルに置かずに、リクエストボディから直接読む
+func uploadPart(c echo.Context, name string) (io.Reader, error) {
+	mr, err := c.Request().MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, errUploadPartNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == name {
+			return part, nil
+		}
+	}
+}
+
+// ChairUpload JSON, NDJSON で一括登録するイス
+// Chair の JSON のフィールドに加えて popularity と stock を受け付ける
+type ChairUpload struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Thumbnail   string `json:"thumbnail"`
+	Price       int64  `json:"price"`
+	Height      int64  `json:"height"`
+	Width       int64  `json:"width"`
+	Depth       int64  `json:"depth"`
+	Color       string `json:"color"`
+	Features    string `json:"features"`
+	Kind        string `json:"kind"`
+	Popularity  int64  `json:"popularity"`
+	Stock       int64  `json:"stock"`
+}
+
+// EstateUpload JSON, NDJSON で一括登録する物件
+// Estate の JSON のフィールドに加えて popularity を受け付ける
+type EstateUpload struct {
+	ID          int64   `json:"id"`
+	Thumbnail   string  `json:"thumbnail"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Address     string  `json:"address"`
+	Rent        int64   `json:"rent"`
+	DoorHeight  int64   `json:"doorHeight"`
+	DoorWidth   int64   `json:"doorWidth"`
+	Features    string  `json:"features"`
+	Popularity  int64   `json:"popularity"`
+}
+
+// readChairs format の形式でイスを1件ずつ読み込み、検証したイスを fn に渡す
+// 読み込めない行や不正な値の行は reject に渡す
+func readChairs(format string, r io.Reader, fn func(line int, chair Chair) error, reject func(line int, reason string) error) error {
+	accept := func(line int, chair Chair) error {
+		if err := validateChair(chair); err != nil {
+			return reject(line, err.Error())
+		}
+		return fn(line, chair)
+	}
+
+	if format != uploadFormatCSV {
+		return readJSON(r, format == uploadFormatNDJSON, func(line int, raw json.RawMessage) error {
+			var u ChairUpload
+			if err := json.Unmarshal(raw, &u); err != nil {
+				return reject(line, err.Error())
+			}
+			return accept(line, Chair{
+				ID:          u.ID,
+				Name:        u.Name,
+				Description: u.Description,
+				Thumbnail:   u.Thumbnail,
+				Price:       u.Price,
+				Height:      u.Height,
+				Width:       u.Width,
+				Depth:       u.Depth,
+				Color:       u.Color,
+				Features:    u.Features,
+				Kind:        u.Kind,
+				Popularity:  u.Popularity,
+				Stock:       u.Stock,
+			})
+		}, reject)
+	}
+
+	return readCSV(r, 13, func(line int, record []string) error {
+		rm := RecordMapper{Record: record}
+		chair := Chair{
+			ID:          int64(rm.NextInt()),
+			Name:        rm.NextString(),
+			Description: rm.NextString(),
+			Thumbnail:   rm.NextString(),
+			Price:       int64(rm.NextInt()),
+			Height:      int64(rm.NextInt()),
+			Width:       int64(rm.NextInt()),
+			Depth:       int64(rm.NextInt()),
+			Color:       rm.NextString(),
+			Features:    rm.NextString(),
+			Kind:        rm.NextString(),
+			Popularity:  int64(rm.NextInt()),
+			Stock:       int64(rm.NextInt()),
+		}
+		if err := rm.Err(); err != nil {
+			return reject(line, err.Error())
+		}
+		return accept(line, chair)
+	}, reject)
+}
+
+// readEstates format の形式で物件を1件ずつ読み込み、検証した物件を fn に渡す
+// 読み込めない行や不正な値の行は reject に渡す
+func readEstates(format string, r io.Reader, fn func(line int, estate Estate) error, reject func(line int, reason string) error) error {
+	accept := func(line int, estate Estate) error {
+		if err := validateEstate(estate); err != nil {
+			return reject(line, err.Error())
+		}
+		return fn(line, estate)
+	}
+
+	if format != uploadFormatCSV {
+		return readJSON(r, format == uploadFormatNDJSON, func(line int, raw json.RawMessage) error {
+			var u EstateUpload
+			if err := json.Unmarshal(raw, &u); err != nil {
+				return reject(line, err.Error())
+			}
+			return accept(line, Estate{
+				ID:          u.ID,
+				Thumbnail:   u.Thumbnail,
+				Name:        u.Name,
+				Description: u.Description,
+				Latitude:    u.Latitude,
+				Longitude:   u.Longitude,
+				Address:     u.Address,
+				Rent:        u.Rent,
+				DoorHeight:  u.DoorHeight,
+				DoorWidth:   u.DoorWidth,
+				Features:    u.Features,
+				Popularity:  u.Popularity,
+			})
+		}, reject)
+	}
+
+	return readCSV(r, 12, func(line int, record []string) error {
+		rm := RecordMapper{Record: record}
+		estate := Estate{
+			ID:          int64(rm.NextInt()),
+			Name:        rm.NextString(),
+			Description: rm.NextString(),
+			Thumbnail:   rm.NextString(),
+			Address:     rm.NextString(),
+			Latitude:    rm.NextFloat(),
+			Longitude:   rm.NextFloat(),
+			Rent:        int64(rm.NextInt()),
+			DoorHeight:  int64(rm.NextInt()),
+			DoorWidth:   int64(rm.NextInt()),
+			Features:    rm.NextString(),
+			Popularity:  int64(rm.NextInt()),
+		}
+		if err := rm.Err(); err != nil {
+			return reject(line, err.Error())
+		}
+		return accept(line, estate)
+	}, reject)
+}
+
+// readJSON JSON の配列、または NDJSON の要素を1つずつ読み込んで fn に渡す
+// 全体を読み込まずに要素ごとにデコードする
+func readJSON(r io.Reader, ndjson bool, fn func(line int, raw json.RawMessage) error, reject func(line int, reason string) error) error {
+	dec := json.NewDecoder(r)
+	if !ndjson {
+		if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+			return reject(1, "expected a JSON array")
+		}
+	}
+
+	line := 1
+	for ; ; line++ {
+		if !ndjson && !dec.More() {
+			break
+		}
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if ndjson && err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 構文が壊れているとそれ以降の要素の区切りがわからなくなるため読み込みを打ち切る
+			return reject(line, err.Error())
+		}
+		if err := fn(line, raw); err != nil {
+			return err
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return reject(line, err.Error())
+	}
+	return nil
+}
+
+func readCSV(r io.Reader, fields int, fn func(line int, record []string) error, reject func(line int, reason string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 壊れたクォートなどはそれ以降のレコードの区切りがわからなくなるため読み込みを打ち切る
+			return reject(line, err.Error())
+		}
+		if len(record) != fields {
+			if err := reject(line, fmt.Sprintf("expected %d fields, but got %d", fields, len(record))); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(line, record); err != nil {
+			return err
+		}
+	}
+}
+
+// respondIngest 一括登録の結果を返す
+// 不正な行があれば 400 とその行を、partial モードや onConflict の指定があれば件数と登録しなかった行の一覧を返す
+func respondIngest(c echo.Context, in *ingester, err error) error {
+	if err != nil {
+		if _, ok := err.(*IngestError); ok {
+			c.Echo().Logger.Infof("post %s failed : %v", in.table.name, err)
+			// トランザクションはロールバックされるので、それまでに数えた件数は返さない
+			return c.JSON(http.StatusBadRequest, IngestReport{Rejected: in.report.Rejected})
+		}
+		c.Logger().Errorf("failed to insert %s: %v", in.table.name, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if in.partial || in.onConflict != onConflictError {
+		return c.JSON(http.StatusCreated, in.report)
+	}
+	return c.NoContent(http.StatusCreated)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func setupChairSearchCondition(t *testing.T) {
+	t.Helper()
+	saved := chairSearchCondition
+	chairSearchCondition = ChairSearchCondition{
+		Color:   ListCondition{List: []string{"黒", "白"}},
+		Kind:    ListCondition{List: []string{"ゲーミングチェア", "座椅子"}},
+		Feature: ListCondition{List: []string{"折りたたみ可", "肘掛け"}},
+	}
+	t.Cleanup(func() {
+		chairSearchCondition = saved
+	})
+}
+
+type readResult struct {
+	chairs   []Chair
+	rejected []IngestError
+}
+
+func readAllChairs(t *testing.T, format, body string) readResult {
+	t.Helper()
+	res := readResult{}
+	err := readChairs(format, strings.NewReader(body), func(line int, chair Chair) error {
+		res.chairs = append(res.chairs, chair)
+		return nil
+	}, func(line int, reason string) error {
+		res.rejected = append(res.rejected, IngestError{Line: line, Reason: reason})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("%v: unexpected error: %v", format, err)
+	}
+	return res
+}
+
+func TestReadChairs_Formats(t *testing.T) {
+	setupChairSearchCondition(t)
+
+	expected := []Chair{
+		{ID: 1, Name: "椅子1", Description: "説明", Thumbnail: "/images/chair/1.png", Price: 1000, Height: 80, Width: 50, Depth: 40, Color: "黒", Features: "肘掛け", Kind: "座椅子", Popularity: 3000, Stock: 5},
+		{ID: 2, Name: "椅子2", Description: "説明, カンマあり", Thumbnail: "/images/chair/2.png", Price: 2000, Height: 90, Width: 60, Depth: 50, Color: "白", Features: "", Kind: "ゲーミングチェア", Popularity: 4000, Stock: 1},
+	}
+
+	// ベンチマーカーの asset.Chair.MarshalJSON と同じフィールド順
+	jsonChairs := []string{
+		`{"id":1,"name":"椅子1","description":"説明","thumbnail":"/images/chair/1.png","price":1000,"height":80,"width":50,"depth":40,"color":"黒","features":"肘掛け","popularity":3000,"kind":"座椅子","stock":5}`,
+		`{"id":2,"name":"椅子2","description":"説明, カンマあり","thumbnail":"/images/chair/2.png","price":2000,"height":90,"width":60,"depth":50,"color":"白","features":"","popularity":4000,"kind":"ゲーミングチェア","stock":1}`,
+	}
+	bodies := map[string]string{
+		uploadFormatCSV: "1,椅子1,説明,/images/chair/1.png,1000,80,50,40,黒,肘掛け,座椅子,3000,5\n" +
+			"2,椅子2,\"説明, カンマあり\",/images/chair/2.png,2000,90,60,50,白,,ゲーミングチェア,4000,1\n",
+		uploadFormatJSON:   "[" + strings.Join(jsonChairs, ",\n") + "]",
+		uploadFormatNDJSON: strings.Join(jsonChairs, "\n") + "\n",
+	}
+
+	for format, body := range bodies {
+		res := readAllChairs(t, format, body)
+		if len(res.rejected) != 0 {
+			t.Errorf("%v: unexpected rejections: %v", format, res.rejected)
+		}
+		if !reflect.DeepEqual(res.chairs, expected) {
+			t.Errorf("%v: unexpected chairs.\nexpected: %+v\nbut got:  %+v", format, expected, res.chairs)
+		}
+	}
+}
+
+func TestReadChairs_Rejections(t *testing.T) {
+	setupChairSearchCondition(t)
+
+	body := "1,椅子1,説明,/images/chair/1.png,1000,80,50,40,黒,肘掛け,座椅子,3000,5\n" +
+		"2,椅子2,説明,/images/chair/2.png,1000,80,50,40,赤,,座椅子,3000,5\n" +
+		"3,椅子3,説明,/images/chair/3.png,-1,80,50,40,黒,,座椅子,3000,5\n" +
+		"4,椅子4,説明,/images/chair/4.png,abc,80,50,40,黒,,座椅子,3000,5\n" +
+		"5,椅子5,説明\n" +
+		"6," + strings.Repeat("長", 65) + ",説明,/images/chair/6.png,1000,80,50,40,黒,,座椅子,3000,5\n" +
+		"7,椅子7,説明,/images/chair/7.png,1000,80,50,40,黒,キャスター付き,座椅子,3000,5\n"
+	res := readAllChairs(t, uploadFormatCSV, body)
+
+	if len(res.chairs) != 1 || res.chairs[0].ID != 1 {
+		t.Errorf("unexpected accepted chairs: %+v", res.chairs)
+	}
+	lines := []int{}
+	for _, e := range res.rejected {
+		lines = append(lines, e.Line)
+	}
+	if expected := []int{2, 3, 4, 5, 6, 7}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected rejected lines. expected: %v, but got: %v (%v)", expected, lines, res.rejected)
+	}
+}
+
+func TestReadChairs_BrokenJSON(t *testing.T) {
+	setupChairSearchCondition(t)
+
+	body := `[{"id":1,"name":"椅子1","thumbnail":"/a.png","color":"黒","kind":"座椅子"},{"id":"two"},{"id":3,`
+	res := readAllChairs(t, uploadFormatJSON, body)
+
+	if len(res.chairs) != 1 {
+		t.Errorf("unexpected accepted chairs: %+v", res.chairs)
+	}
+	if len(res.rejected) != 2 || res.rejected[0].Line != 2 || res.rejected[1].Line != 3 {
+		t.Errorf("unexpected rejections: %v", res.rejected)
+	}
+}
+
+// CSV のファイルは他のフィールドの後ろにあっても、一時ファイルを経由せずにそのまま読める
+func TestUploadPart(t *testing.T) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("note", "ignored")
+	fw, err := w.CreateFormFile("chairs", "chairs.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte("1,椅子1\n"))
+	w.Close()
+
+	e := echo.New()
+	newContext := func() echo.Context {
+		req := httptest.NewRequest(http.MethodPost, "/api/chair", bytes.NewReader(buf.Bytes()))
+		req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+		return e.NewContext(req, httptest.NewRecorder())
+	}
+
+	part, err := uploadPart(newContext(), "chairs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "1,椅子1\n" {
+		t.Errorf("unexpected part body: %q", b)
+	}
+
+	if _, err := uploadPart(newContext(), "estates"); err != errUploadPartNotFound {
+		t.Errorf("uploadPart() error = %v, want %v", err, errUploadPartNotFound)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/chair", strings.NewReader("1,椅子1\n"))
+	if _, err := uploadPart(e.NewContext(req, httptest.NewRecorder()), "chairs"); err == nil {
+		t.Error("uploadPart() should fail without multipart/form-data")
+	}
+}
+
+// strict モードで不正な行があればロールバックされるので、それまでに数えた件数を返さない
+func TestRespondIngest_RolledBack(t *testing.T) {
+	in := newIngester(context.Background(), nil, chairIngestTable(), ingestOption{onConflict: onConflictUpdate})
+	in.report.Inserted = 3
+	in.report.Updated = 2
+	in.report.Skipped = 1
+	err := in.Reject(7, "invalid color")
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/api/chair", nil), rec)
+	if err := respondIngest(c, in, err); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status. expected: %v, but got: %v", http.StatusBadRequest, rec.Code)
+	}
+	var report IngestReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	expected := IngestReport{Rejected: []IngestError{{Line: 7, Reason: "invalid color"}}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("unexpected report. expected: %+v, but got: %+v", expected, report)
+	}
+}
+
+// 予約中のイスを上書きするときは、PUT /api/chair/:id と同じく予約数を差し引いた在庫を保存する
+func TestPostChair_UpsertWithReservation(t *testing.T) {
+	setupTestDB(t)
+	setupChairSearchCondition(t)
+
+	insertTestChair(t, 4, "座椅子")
+	_, err := db.Exec("INSERT INTO chair_reservation(token, chair_id, expires_at) VALUES(?, ?, ?)",
+		fmt.Sprintf("ingest-test-%d", time.Now().UnixNano()), testChairID, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal("failed to insert reservation:", err)
+	}
+
+	e := echo.New()
+	e.POST("/api/chair", postChair)
+
+	tests := []struct {
+		name    string
+		stock   int64
+		report  IngestReport
+		wantNow int64
+	}{
+		{name: "stock above reserved", stock: 10, report: IngestReport{Updated: 1, Rejected: []IngestError{}}, wantNow: 9},
+		{name: "stock below reserved", stock: 0, report: IngestReport{Rejected: []IngestError{{Line: 1, Reason: "stock 0 is less than 1 reserved"}}}, wantNow: 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`[{"id":%d,"name":"test","thumbnail":"/images/chair/test.png","price":1000,"height":100,"width":100,"depth":100,"color":"白","kind":"座椅子","stock":%d}]`, testChairID, tt.stock)
+			req := httptest.NewRequest(http.MethodPost, "/api/chair?mode=partial&onConflict=update", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("unexpected status. expected: %v, but got: %v", http.StatusCreated, rec.Code)
+			}
+			var report IngestReport
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(report, tt.report) {
+				t.Errorf("unexpected report. expected: %+v, but got: %+v", tt.report, report)
+			}
+
+			var stock int64
+			if err := db.Get(&stock, "SELECT stock FROM chair WHERE id = ?", testChairID); err != nil {
+				t.Fatal(err)
+			}
+			if stock != tt.wantNow {
+				t.Errorf("unexpected stock. expected: %v, but got: %v", tt.wantNow, stock)
+			}
+		})
+	}
+}
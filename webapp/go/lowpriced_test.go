@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/isucon/isucon10-qualify/isuumo/cache"
+)
+
+// setupLowPricedChairCache Limit 件の安価なイスをキャッシュした状態にする
+// 価格は 1000, 1001, ... で、最後のイスの価格が一覧に入る上限になる
+func setupLowPricedChairCache(t *testing.T) []Chair {
+	t.Helper()
+	saved := lowPricedChairCache
+	lowPricedChairCache = cache.New()
+	t.Cleanup(func() {
+		lowPricedChairCache = saved
+	})
+
+	chairs := make([]Chair, 0, Limit)
+	for i := 0; i < Limit; i++ {
+		chairs = append(chairs, Chair{ID: int64(i + 1), Price: int64(1000 + i), Stock: 1})
+	}
+	_, gen, _ := lowPricedChairCache.Get()
+	lowPricedChairCache.Set(gen, chairs)
+	return chairs
+}
+
+// 一覧にあるイスの最後の1脚が売れたら、一覧から外れるのでキャッシュを捨てる
+func TestInvalidateLowPricedChair_SoldOut(t *testing.T) {
+	chairs := setupLowPricedChairCache(t)
+
+	sold := chairs[3]
+	sold.Stock = 0
+	invalidateLowPricedChairIfAffected([]Chair{sold})
+
+	if _, _, ok := lowPricedChairCache.Get(); ok {
+		t.Error("cache should be invalidated when a cached chair is sold out")
+	}
+}
+
+// 一覧の最後のイスより高いイスは一覧に入らないのでキャッシュを残す
+func TestInvalidateLowPricedChair_AboveCutoff(t *testing.T) {
+	chairs := setupLowPricedChairCache(t)
+	last := chairs[len(chairs)-1]
+
+	invalidateLowPricedChairIfAffected([]Chair{{ID: 100000, Price: last.Price + 1, Stock: 1}})
+	if _, _, ok := lowPricedChairCache.Get(); !ok {
+		t.Error("cache should be kept for a chair priced above the cutoff")
+	}
+
+	invalidateLowPricedChairIfAffected([]Chair{{ID: 100000, Price: last.Price - 1, Stock: 1}})
+	if _, _, ok := lowPricedChairCache.Get(); ok {
+		t.Error("cache should be invalidated for a chair priced below the cutoff")
+	}
+}
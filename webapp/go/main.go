@@ -1,33 +1,77 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"encoding/csv"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"github.com/labstack/gommon/log"
+
+	"github.com/isucon/isucon10-qualify/isuumo/botfilter"
+	"github.com/isucon/isucon10-qualify/isuumo/cache"
+	"github.com/isucon/isucon10-qualify/isuumo/geometry"
+	"github.com/isucon/isucon10-qualify/isuumo/metrics"
+	"github.com/isucon/isucon10-qualify/isuumo/thumbnail"
 )
 
 const Limit = 20
 const NazotteLimit = 50
 
+// InitializeTimeout ベンチマーカーの初期化タイムアウト(30秒)に収まるように設定する
+const InitializeTimeout = 25 * time.Second
+
+// ShutdownTimeout 終了時に処理中のリクエストを待つ時間
+const ShutdownTimeout = 10 * time.Second
+
+// estateColumns, chairColumns 各テーブルのうち Estate, Chair 構造体に対応するカラム
+// point や *_range_id カラムは検索専用のため SELECT * ではなくこちらを使う
+const estateColumns = "id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity, version"
+const chairColumns = "id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock, version"
+
 var db *sqlx.DB
 var mySQLConnectionData *MySQLConnectionEnv
 var chairSearchCondition ChairSearchCondition
 var estateSearchCondition EstateSearchCondition
 
+// lowPricedChairCache, lowPricedEstateCache トップページの安価なイス・物件一覧
+var lowPricedChairCache = cache.New()
+var lowPricedEstateCache = cache.New()
+
+// routeLatencies ルートごとのレイテンシのヒストグラム
+var routeLatencies = metrics.NewRouteHistograms(metrics.DefaultLatencyBuckets)
+
+// metricsRegistry /metrics で Prometheus 形式で公開するメトリクス
+var metricsRegistry = metrics.NewRegistry()
+
+var chairsBought = metricsRegistry.Counter("isuumo_chairs_bought_total", "Number of chairs bought.")
+var documentsRequested = metricsRegistry.Counter("isuumo_documents_requested_total", "Number of estate documents requested.")
+var chairRowsInserted = metricsRegistry.Counter("isuumo_ingest_rows_inserted_total", "Number of rows inserted via bulk upload.", metrics.Label{Name: "table", Value: "chair"})
+var estateRowsInserted = metricsRegistry.Counter("isuumo_ingest_rows_inserted_total", "Number of rows inserted via bulk upload.", metrics.Label{Name: "table", Value: "estate"})
+
+// botFilter ボットからのリクエストを拒否するフィルタ
+var botFilter *botfilter.Filter
+
+// nazotteInGo なぞって検索の多角形判定を MySQL ではなく Go で行うか
+var nazotteInGo bool
+
 type InitializeResponse struct {
 	Language string `json:"language"`
 }
@@ -46,11 +90,15 @@ type Chair struct {
 	Kind        string `db:"kind" json:"kind"`
 	Popularity  int64  `db:"popularity" json:"-"`
 	Stock       int64  `db:"stock" json:"-"`
+	Version     int64  `db:"version" json:"version"`
 }
 
 type ChairSearchResponse struct {
-	Count  int64   `json:"count"`
-	Chairs []Chair `json:"chairs"`
+	Count      int64   `json:"count"`
+	Chairs     []Chair `json:"chairs"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+	// Facets facets=true のときの選択肢ごとの件数
+	Facets SearchFacets `json:"facets,omitempty"`
 }
 
 type ChairListResponse struct {
@@ -71,12 +119,16 @@ type Estate struct {
 	DoorWidth   int64   `db:"door_width" json:"doorWidth"`
 	Features    string  `db:"features" json:"features"`
 	Popularity  int64   `db:"popularity" json:"-"`
+	Version     int64   `db:"version" json:"version"`
 }
 
 //EstateSearchResponse estate/searchへのレスポンスの形式
 type EstateSearchResponse struct {
-	Count   int64    `json:"count"`
-	Estates []Estate `json:"estates"`
+	Count      int64    `json:"count"`
+	Estates    []Estate `json:"estates"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+	// Facets facets=true のときの選択肢ごとの件数
+	Facets SearchFacets `json:"facets,omitempty"`
 }
 
 type EstateListResponse struct {
@@ -88,8 +140,23 @@ type Coordinate struct {
 	Longitude float64 `json:"longitude"`
 }
 
+// Coordinates なぞって検索の範囲
+// coordinates (と holes) で1つの多角形を、polygons で複数の多角形を指定する
 type Coordinates struct {
-	Coordinates []Coordinate `json:"coordinates"`
+	Coordinates []Coordinate     `json:"coordinates"`
+	Holes       [][]Coordinate   `json:"holes,omitempty"`
+	Polygons    []NazottePolygon `json:"polygons,omitempty"`
+}
+
+// NazottePolygon 外周 coordinates と、くり抜く穴 holes からなる多角形
+type NazottePolygon struct {
+	Coordinates []Coordinate   `json:"coordinates"`
+	Holes       [][]Coordinate `json:"holes,omitempty"`
+}
+
+// NazotteErrorResponse 範囲が不正な場合のレスポンス
+type NazotteErrorResponse struct {
+	Reason string `json:"reason"`
 }
 
 type Range struct {
@@ -125,13 +192,6 @@ type ChairSearchCondition struct {
 	Kind    ListCondition  `json:"kind"`
 }
 
-type BoundingBox struct {
-	// TopLeftCorner 緯度経度が共に最小値になるような点の情報を持っている
-	TopLeftCorner Coordinate
-	// BottomRightCorner 緯度経度が共に最大値になるような点の情報を持っている
-	BottomRightCorner Coordinate
-}
-
 type MySQLConnectionEnv struct {
 	Host     string
 	Port     string
@@ -218,7 +278,7 @@ func getEnv(key, defaultValue string) string {
 
 //ConnectDB isuumoデータベースに接続する
 func (mc *MySQLConnectionEnv) ConnectDB() (*sqlx.DB, error) {
-	dsn := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", mc.User, mc.Password, mc.Host, mc.Port, mc.DBName)
+	dsn := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?parseTime=true", mc.User, mc.Password, mc.Host, mc.Port, mc.DBName)
 	return sqlx.Open("mysql", dsn)
 }
 
@@ -236,6 +296,9 @@ func init() {
 		os.Exit(1)
 	}
 	json.Unmarshal(jsonText, &estateSearchCondition)
+
+	chairFeatureIDs = newFeatureIDs(chairSearchCondition.Feature)
+	estateFeatureIDs = newFeatureIDs(estateSearchCondition.Feature)
 }
 
 func main() {
@@ -245,9 +308,34 @@ func main() {
 	e.Logger.SetLevel(log.DEBUG)
 
 	// Middleware
-	e.Use(middleware.Logger())
+	e.Use(metrics.AccessLog(os.Stdout, routeLatencies))
 	e.Use(middleware.Recover())
 
+	botPatterns := botfilter.DefaultPatterns
+	if path := getEnv("BOT_PATTERNS_FILE", ""); path != "" {
+		var err error
+		botPatterns, err = botfilter.LoadPatterns(path)
+		if err != nil {
+			e.Logger.Fatalf("failed to load bot patterns : %v", err)
+		}
+	}
+	var err error
+	botFilter, err = botfilter.New(botPatterns)
+	if err != nil {
+		e.Logger.Fatalf("failed to compile bot patterns : %v", err)
+	}
+	e.Use(botFilter.Middleware())
+
+	thumbnailStore, err = thumbnail.NewStore(getEnv("THUMBNAIL_DIR", filepath.Join("..", "thumbnails")))
+	if err != nil {
+		e.Logger.Fatalf("failed to create thumbnail store : %v", err)
+	}
+
+	// Debug
+	e.GET("/debug/bot_filter", getBotFilterStats)
+	e.GET("/debug/metrics", getDebugMetrics)
+	e.GET("/metrics", metricsRegistry.Handler())
+
 	// Initialize
 	e.POST("/initialize", initialize)
 
@@ -258,56 +346,203 @@ func main() {
 	e.GET("/api/chair/low_priced", getLowPricedChair)
 	e.GET("/api/chair/search/condition", getChairSearchCondition)
 	e.POST("/api/chair/buy/:id", buyChair)
+	e.POST("/api/chair/buy", buyChairs)
+	e.POST("/api/chair/:id/reserve", reserveChair)
+	e.PUT("/api/chair/:id", putChair)
+	e.DELETE("/api/chair/:id", deleteChair)
+	e.POST("/api/chair/:id/thumbnail", postChairThumbnail)
 
 	// Estate Handler
 	e.GET("/api/estate/:id", getEstateDetail)
 	e.POST("/api/estate", postEstate)
+	e.PATCH("/api/estate/:id", patchEstate)
+	e.DELETE("/api/estate/:id", deleteEstate)
+	e.POST("/api/estate/:id/thumbnail", postEstateThumbnail)
 	e.GET("/api/estate/search", searchEstates)
+	e.GET("/api/estate/near", searchEstatesNear)
 	e.GET("/api/estate/low_priced", getLowPricedEstate)
 	e.POST("/api/estate/req_doc/:id", postEstateRequestDocument)
 	e.POST("/api/estate/nazotte", searchEstateNazotte)
 	e.GET("/api/estate/search/condition", getEstateSearchCondition)
 	e.GET("/api/recommended_estate/:id", searchRecommendedEstateWithChair)
+	e.GET("/api/recommended_chair/:id", searchRecommendedChairWithEstate)
+
+	// Order Handler
+	e.GET("/api/orders", getChairOrders)
+	e.GET("/api/document_requests", getEstateDocumentRequests)
+
+	// Thumbnail Handler
+	e.GET("/api/thumbnails/:name", thumbnailStore.Handler())
 
 	mySQLConnectionData = NewMySQLConnectionEnv()
+	nazotteInGo = getEnv("NAZOTTE_EVALUATOR", "mysql") == "go"
 
-	var err error
 	db, err = mySQLConnectionData.ConnectDB()
 	if err != nil {
 		e.Logger.Fatalf("DB connection failed : %v", err)
 	}
 	db.SetMaxOpenConns(10)
 	defer db.Close()
+	registerRuntimeMetrics()
+
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		runReservationSweeper(sweeperCtx, ReservationSweepInterval, e.Logger)
+	}()
 
 	// Start server
 	serverPort := fmt.Sprintf(":%v", getEnv("SERVER_PORT", "1323"))
-	e.Logger.Fatal(e.Start(serverPort))
+	go func() {
+		if err := e.Start(serverPort); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// シグナルを受け取ったら処理中のリクエストとスイーパーの終了を待ってから終了する
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Errorf("failed to shutdown server : %v", err)
+	}
+	stopSweeper()
+	<-sweeperDone
+}
+
+type BotFilterStatsResponse struct {
+	Rejected uint64                  `json:"rejected"`
+	Patterns []botfilter.PatternStat `json:"patterns"`
+}
+
+func getBotFilterStats(c echo.Context) error {
+	return c.JSON(http.StatusOK, BotFilterStatsResponse{
+		Rejected: botFilter.Rejected(),
+		Patterns: botFilter.Stats(),
+	})
+}
+
+type RouteMetrics struct {
+	Route string `json:"route"`
+	metrics.HistogramSnapshot
+}
+
+type DebugMetricsResponse struct {
+	Routes []RouteMetrics `json:"routes"`
+}
+
+// getDebugMetrics ルートごとのレイテンシを合計時間の長い順に返す
+func getDebugMetrics(c echo.Context) error {
+	routes := []RouteMetrics{}
+	for route, h := range routeLatencies.Snapshot() {
+		routes = append(routes, RouteMetrics{Route: route, HistogramSnapshot: h})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Sum > routes[j].Sum
+	})
+	return c.JSON(http.StatusOK, DebugMetricsResponse{Routes: routes})
+}
+
+// registerRuntimeMetrics リクエストごとのレイテンシ、DB のコネクション、goroutine 数をメトリクスに登録する
+// DB の値はスクレイプのたびにグローバルな db から取得する
+func registerRuntimeMetrics() {
+	metricsRegistry.RouteHistograms("isuumo_http_request_duration_seconds", "HTTP request latency by route.", routeLatencies)
+
+	metricsRegistry.GaugeFunc("isuumo_db_open_connections", "Number of established connections to the database.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	metricsRegistry.GaugeFunc("isuumo_db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	metricsRegistry.GaugeFunc("isuumo_db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	metricsRegistry.CounterFunc("isuumo_db_wait_count_total", "Number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	metricsRegistry.CounterFunc("isuumo_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+
+	metricsRegistry.GaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
 }
 
 func initialize(c echo.Context) error {
 	sqlDir := filepath.Join("..", "mysql", "db")
 	paths := []string{
-		filepath.Join(sqlDir, "0_Schema.sql"),
+		// 他の言語の実装と共有している ../mysql/db/0_Schema.sql ではなく、Go の実装用のスキーマを使う
+		filepath.Join(sqlDir, "go", "0_Schema.sql"),
 		filepath.Join(sqlDir, "1_DummyEstateData.sql"),
 		filepath.Join(sqlDir, "2_DummyChairData.sql"),
 	}
 
+	ctx, cancel := context.WithTimeout(c.Request().Context(), InitializeTimeout)
+	defer cancel()
+
+	// 0_Schema.sql はデータベースを作り直すため、同じ接続上で全ファイルを実行し最後に USE し直す
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		c.Logger().Errorf("Initialize failed to get connection : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer conn.Close()
+
 	for _, p := range paths {
-		sqlFile, _ := filepath.Abs(p)
-		cmdStr := fmt.Sprintf("mysql -h %v -u %v -p%v -P %v %v < %v",
-			mySQLConnectionData.Host,
-			mySQLConnectionData.User,
-			mySQLConnectionData.Password,
-			mySQLConnectionData.Port,
-			mySQLConnectionData.DBName,
-			sqlFile,
-		)
-		if err := exec.Command("bash", "-c", cmdStr).Run(); err != nil {
+		start := time.Now()
+		n, err := execSQLFile(ctx, conn, p)
+		if err != nil {
 			c.Logger().Errorf("Initialize script error : %v", err)
 			return c.NoContent(http.StatusInternalServerError)
 		}
+		c.Logger().Infof("Initialize executed %d statements from %s in %v", n, filepath.Base(p), time.Since(start))
+	}
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("USE `%s`", mySQLConnectionData.DBName)); err != nil {
+		c.Logger().Errorf("Initialize failed to select database : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 初期データの features カラムから特徴テーブルを作る
+	for table, featureIDs := range map[string]map[string]int64{"chair": chairFeatureIDs, "estate": estateFeatureIDs} {
+		start := time.Now()
+		n, err := loadFeatures(ctx, conn, table, featureIDs)
+		if err != nil {
+			c.Logger().Errorf("Initialize failed to load %s features : %v", table, err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		c.Logger().Infof("Initialize inserted %d rows into %s_feature in %v", n, table, time.Since(start))
+	}
+
+	// 初期データの各 *_range_id カラムを検索条件の Range から計算する
+	rangeIDUpdates := []string{
+		fmt.Sprintf("UPDATE chair SET price_range_id = %s, height_range_id = %s, width_range_id = %s, depth_range_id = %s",
+			rangeIDCase(chairSearchCondition.Price, "price"),
+			rangeIDCase(chairSearchCondition.Height, "height"),
+			rangeIDCase(chairSearchCondition.Width, "width"),
+			rangeIDCase(chairSearchCondition.Depth, "depth"),
+		),
+		fmt.Sprintf("UPDATE estate SET rent_range_id = %s, door_height_range_id = %s, door_width_range_id = %s",
+			rangeIDCase(estateSearchCondition.Rent, "rent"),
+			rangeIDCase(estateSearchCondition.DoorHeight, "door_height"),
+			rangeIDCase(estateSearchCondition.DoorWidth, "door_width"),
+		),
+	}
+	for _, q := range rangeIDUpdates {
+		if _, err := conn.ExecContext(ctx, q); err != nil {
+			c.Logger().Errorf("Initialize failed to update range ids : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
 	}
 
+	lowPricedChairCache.Invalidate()
+	lowPricedEstateCache.Invalidate()
+
 	return c.JSON(http.StatusOK, InitializeResponse{
 		Language: "go",
 	})
@@ -321,7 +556,7 @@ func getChairDetail(c echo.Context) error {
 	}
 
 	chair := Chair{}
-	query := `SELECT * FROM chair WHERE id = ?`
+	query := `SELECT ` + chairColumns + ` FROM chair WHERE id = ?`
 	err = db.Get(&chair, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -339,64 +574,59 @@ func getChairDetail(c echo.Context) error {
 }
 
 func postChair(c echo.Context) error {
-	header, err := c.FormFile("chairs")
-	if err != nil {
-		c.Logger().Errorf("failed to get form file: %v", err)
+	opt, ok := ingestOptions(c)
+	if !ok {
+		c.Echo().Logger.Infof("post chair failed : invalid options \"%v\"", c.QueryString())
 		return c.NoContent(http.StatusBadRequest)
 	}
-	f, err := header.Open()
-	if err != nil {
-		c.Logger().Errorf("failed to open form file: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
-	}
-	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
-	if err != nil {
-		c.Logger().Errorf("failed to read csv: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+
+	format := uploadFormat(c)
+	var body io.Reader = c.Request().Body
+	if format == uploadFormatCSV {
+		part, err := uploadPart(c, "chairs")
+		if err != nil {
+			c.Logger().Errorf("failed to get form file: %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
+		body = part
 	}
 
-	tx, err := db.Begin()
+	tx, err := db.Beginx()
 	if err != nil {
 		c.Logger().Errorf("failed to begin tx: %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 	defer tx.Rollback()
-	for _, row := range records {
-		rm := RecordMapper{Record: row}
-		id := rm.NextInt()
-		name := rm.NextString()
-		description := rm.NextString()
-		thumbnail := rm.NextString()
-		price := rm.NextInt()
-		height := rm.NextInt()
-		width := rm.NextInt()
-		depth := rm.NextInt()
-		color := rm.NextString()
-		features := rm.NextString()
-		kind := rm.NextString()
-		popularity := rm.NextInt()
-		stock := rm.NextInt()
-		if err := rm.Err(); err != nil {
-			c.Logger().Errorf("failed to read record: %v", err)
-			return c.NoContent(http.StatusBadRequest)
-		}
-		_, err := tx.Exec("INSERT INTO chair(id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, price, height, width, depth, color, features, kind, popularity, stock)
-		if err != nil {
-			c.Logger().Errorf("failed to insert chair: %v", err)
-			return c.NoContent(http.StatusInternalServerError)
+
+	in := newIngester(c.Request().Context(), tx, chairIngestTable(), opt)
+	// 安価なイス一覧に入るかは最も安いイスだけで決まるので、読んだイスは保持せず最安のものだけ覚えておく
+	cheapest := []Chair{}
+	err = readChairs(format, body, func(line int, chair Chair) error {
+		if chair.Stock > 0 && (len(cheapest) == 0 || chair.Price < cheapest[0].Price || (chair.Price == cheapest[0].Price && chair.ID < cheapest[0].ID)) {
+			cheapest = []Chair{{ID: chair.ID, Price: chair.Price, Stock: chair.Stock}}
 		}
+		return in.Add(chairIngestRow(line, chair))
+	}, in.Reject)
+	if err == nil {
+		err = in.Flush()
 	}
-	if err := tx.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return respondIngest(c, in, err)
 	}
-	return c.NoContent(http.StatusCreated)
+	chairRowsInserted.Add(uint64(in.report.Inserted))
+	// 上書きしたイスが一覧にあった場合、値上げや在庫切れに気付けないためキャッシュを捨てる
+	if in.report.Updated > 0 {
+		lowPricedChairCache.Invalidate()
+	}
+	invalidateLowPricedChairIfAffected(cheapest)
+	return respondIngest(c, in, nil)
 }
 
 func searchChairs(c echo.Context) error {
-	conditions := make([]string, 0)
-	params := make([]interface{}, 0)
+	filters := searchFilters{}
 
 	if c.QueryParam("priceRangeId") != "" {
 		chairPrice, err := getRange(chairSearchCondition.Price, c.QueryParam("priceRangeId"))
@@ -405,14 +635,7 @@ func searchChairs(c echo.Context) error {
 			return c.NoContent(http.StatusBadRequest)
 		}
 
-		if chairPrice.Min != -1 {
-			conditions = append(conditions, "price >= ?")
-			params = append(params, chairPrice.Min)
-		}
-		if chairPrice.Max != -1 {
-			conditions = append(conditions, "price < ?")
-			params = append(params, chairPrice.Max)
-		}
+		filters.add("price", "price_range_id = ?", chairPrice.ID)
 	}
 
 	if c.QueryParam("heightRangeId") != "" {
@@ -422,14 +645,7 @@ func searchChairs(c echo.Context) error {
 			return c.NoContent(http.StatusBadRequest)
 		}
 
-		if chairHeight.Min != -1 {
-			conditions = append(conditions, "height >= ?")
-			params = append(params, chairHeight.Min)
-		}
-		if chairHeight.Max != -1 {
-			conditions = append(conditions, "height < ?")
-			params = append(params, chairHeight.Max)
-		}
+		filters.add("height", "height_range_id = ?", chairHeight.ID)
 	}
 
 	if c.QueryParam("widthRangeId") != "" {
@@ -439,14 +655,7 @@ func searchChairs(c echo.Context) error {
 			return c.NoContent(http.StatusBadRequest)
 		}
 
-		if chairWidth.Min != -1 {
-			conditions = append(conditions, "width >= ?")
-			params = append(params, chairWidth.Min)
-		}
-		if chairWidth.Max != -1 {
-			conditions = append(conditions, "width < ?")
-			params = append(params, chairWidth.Max)
-		}
+		filters.add("width", "width_range_id = ?", chairWidth.ID)
 	}
 
 	if c.QueryParam("depthRangeId") != "" {
@@ -456,44 +665,54 @@ func searchChairs(c echo.Context) error {
 			return c.NoContent(http.StatusBadRequest)
 		}
 
-		if chairDepth.Min != -1 {
-			conditions = append(conditions, "depth >= ?")
-			params = append(params, chairDepth.Min)
-		}
-		if chairDepth.Max != -1 {
-			conditions = append(conditions, "depth < ?")
-			params = append(params, chairDepth.Max)
-		}
+		filters.add("depth", "depth_range_id = ?", chairDepth.ID)
 	}
 
 	if c.QueryParam("kind") != "" {
-		conditions = append(conditions, "kind = ?")
-		params = append(params, c.QueryParam("kind"))
+		filters.add("kind", "kind = ?", c.QueryParam("kind"))
 	}
 
 	if c.QueryParam("color") != "" {
-		conditions = append(conditions, "color = ?")
-		params = append(params, c.QueryParam("color"))
+		filters.add("color", "color = ?", c.QueryParam("color"))
 	}
 
 	if c.QueryParam("features") != "" {
-		for _, f := range strings.Split(c.QueryParam("features"), ",") {
-			conditions = append(conditions, "features LIKE CONCAT('%', ?, '%')")
-			params = append(params, f)
-		}
+		cond, featureParams := featureCondition("chair", chairFeatureIDs, splitFeatures(c.QueryParam("features")))
+		filters.add("", cond, featureParams...)
 	}
 
-	if len(conditions) == 0 {
+	keyword := c.QueryParam("keyword")
+	if keyword != "" {
+		filters.add("", keywordMatch, keyword)
+	}
+
+	if len(filters) == 0 {
 		c.Echo().Logger.Infof("Search condition not found")
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	conditions = append(conditions, "stock > 0")
+	filters.add("", "stock > 0")
 
-	page, err := strconv.Atoi(c.QueryParam("page"))
-	if err != nil {
-		c.Logger().Infof("Invalid format page parameter : %v", err)
+	// cursor が指定された場合は page の代わりに前ページ末尾の (popularity, id) から続きを取得する
+	var cursor *searchCursor
+	page := 0
+	if c.QueryParam("cursor") != "" && keyword != "" {
+		c.Logger().Infof("cursor parameter cannot be used with keyword")
 		return c.NoContent(http.StatusBadRequest)
+	} else if c.QueryParam("cursor") != "" {
+		var err error
+		cursor, err = decodeSearchCursor(c.QueryParam("cursor"))
+		if err != nil {
+			c.Logger().Infof("Invalid format cursor parameter : %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
+	} else {
+		var err error
+		page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			c.Logger().Infof("Invalid format page parameter : %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
 	}
 
 	perPage, err := strconv.Atoi(c.QueryParam("perPage"))
@@ -502,10 +721,17 @@ func searchChairs(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	searchQuery := "SELECT * FROM chair WHERE "
+	withFacets, err := facetsRequested(c.QueryParam("facets"))
+	if err != nil {
+		c.Logger().Infof("Invalid format facets parameter : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	searchQuery := "SELECT " + chairColumns + " FROM chair WHERE "
 	countQuery := "SELECT COUNT(*) FROM chair WHERE "
-	searchCondition := strings.Join(conditions, " AND ")
-	limitOffset := " ORDER BY popularity DESC, id ASC LIMIT ? OFFSET ?"
+	searchCondition, params := filters.where("")
+	orderBy := " ORDER BY popularity DESC, id ASC"
+	limitOffset := " LIMIT ? OFFSET ?"
 
 	var res ChairSearchResponse
 	err = db.Get(&res.Count, countQuery+searchCondition, params...)
@@ -514,9 +740,28 @@ func searchChairs(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if withFacets {
+		res.Facets, err = countFacets("chair", filters, chairFacetColumns(), chairSearchCondition.Feature)
+		if err != nil {
+			c.Logger().Errorf("searchChairs facets DB execution error : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if cursor != nil {
+		searchCondition += " AND " + cursorCondition
+		params = append(params, cursor.Popularity, cursor.Popularity, cursor.ID)
+	}
+
+	if keyword != "" {
+		orderBy = " ORDER BY " + keywordMatch + " DESC, popularity DESC, id ASC"
+		params = append(params, keyword)
+	}
+
+	// 次のページの有無を判定するため1件多く取得する
 	chairs := []Chair{}
-	params = append(params, perPage, page*perPage)
-	err = db.Select(&chairs, searchQuery+searchCondition+limitOffset, params...)
+	params = append(params, perPage+1, page*perPage)
+	err = db.Select(&chairs, searchQuery+searchCondition+orderBy+limitOffset, params...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusOK, ChairSearchResponse{Count: 0, Chairs: []Chair{}})
@@ -525,6 +770,15 @@ func searchChairs(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if perPage >= 0 && len(chairs) > perPage {
+		chairs = chairs[:perPage]
+		// keyword 指定時は関連度順に並ぶため cursor は発行しない
+		if perPage > 0 && keyword == "" {
+			last := chairs[perPage-1]
+			res.NextCursor = encodeSearchCursor(searchCursor{Popularity: last.Popularity, ID: last.ID})
+		}
+	}
+
 	res.Chairs = chairs
 
 	return c.JSON(http.StatusOK, res)
@@ -537,12 +791,18 @@ func buyChair(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	_, ok := m["email"].(string)
+	email, ok := m["email"].(string)
 	if !ok {
 		c.Echo().Logger.Info("post buy chair failed : email not found in request body")
 		return c.NoContent(http.StatusBadRequest)
 	}
 
+	key, ok := idempotencyKey(c)
+	if !ok {
+		c.Echo().Logger.Info("post buy chair failed : idempotency key too long")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Echo().Logger.Infof("post buy chair failed : %v", err)
@@ -556,11 +816,54 @@ func buyChair(c echo.Context) error {
 	}
 	defer tx.Rollback()
 
+	// 同じ購入者の同じキーのリクエストが処理中の場合は、そのトランザクションが終わるまでここで待たされる
+	if key.Valid {
+		_, err = tx.Exec("INSERT INTO chair_buy_idempotency(email, idempotency_key, chair_id) VALUES(?, ?, ?)", email, key, id)
+		if err != nil {
+			if isDuplicateEntry(err) {
+				tx.Rollback()
+				return replayBuyChair(c, email, key.String, int64(id))
+			}
+			c.Echo().Logger.Errorf("idempotency key insert failed : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	// 予約のトークンが指定された場合は、予約時に確保した在庫を購入する
+	if token, ok := m["reservationToken"].(string); ok {
+		status, err := consumeReservation(tx, token, int64(id))
+		if err != nil {
+			c.Echo().Logger.Errorf("DB Execution Error: on consuming a reservation : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if status == http.StatusOK {
+			_, err = tx.Exec("INSERT INTO chair_order(chair_id, email) VALUES(?, ?)", id, email)
+			if err != nil {
+				c.Echo().Logger.Errorf("chair order insert failed : %v", err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
+		} else {
+			c.Echo().Logger.Infof("buyChair reservation \"%v\" for chair id \"%v\" unavailable : %v", token, id, status)
+		}
+		if err := commitBuyChairStatus(tx, email, key, status); err != nil {
+			c.Echo().Logger.Errorf("transaction commit error : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if status == http.StatusOK {
+			chairsBought.Inc()
+		}
+		return c.NoContent(status)
+	}
+
 	var chair Chair
-	err = tx.QueryRowx("SELECT * FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
+	err = tx.QueryRowx("SELECT "+chairColumns+" FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Echo().Logger.Infof("buyChair chair id \"%v\" not found", id)
+			if err := commitBuyChairStatus(tx, email, key, http.StatusNotFound); err != nil {
+				c.Echo().Logger.Errorf("transaction commit error : %v", err)
+				return c.NoContent(http.StatusInternalServerError)
+			}
 			return c.NoContent(http.StatusNotFound)
 		}
 		c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
@@ -573,22 +876,177 @@ func buyChair(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	err = tx.Commit()
+	_, err = tx.Exec("INSERT INTO chair_order(chair_id, email) VALUES(?, ?)", id, email)
+	if err != nil {
+		c.Echo().Logger.Errorf("chair order insert failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	err = commitBuyChairStatus(tx, email, key, http.StatusOK)
 	if err != nil {
 		c.Echo().Logger.Errorf("transaction commit error : %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	chairsBought.Inc()
+
+	// 在庫は一覧のレスポンスに含まれないため、売り切れた場合のみ影響する
+	if chair.Stock <= 1 {
+		chair.Stock = 0
+		invalidateLowPricedChairIfAffected([]Chair{chair})
+	}
 
 	return c.NoContent(http.StatusOK)
 }
 
+// MaxCartItems 一度に購入できるイスの種類数の上限
+const MaxCartItems = 100
+
+const (
+	CartItemErrorNotFound          = "not_found"
+	CartItemErrorInsufficientStock = "insufficient_stock"
+)
+
+type CartItem struct {
+	ID       int64 `json:"id"`
+	Quantity int64 `json:"quantity"`
+}
+
+type BuyChairsRequest struct {
+	Email string     `json:"email"`
+	Items []CartItem `json:"items"`
+}
+
+type BuyChairsResponse struct {
+	Items []CartItem `json:"items"`
+}
+
+// CartItemError 購入できなかったイスとその理由
+type CartItemError struct {
+	ID       int64  `json:"id"`
+	Quantity int64  `json:"quantity"`
+	Stock    int64  `json:"stock"`
+	Reason   string `json:"reason"`
+}
+
+type BuyChairsErrorResponse struct {
+	Errors []CartItemError `json:"errors"`
+}
+
+// buyChairs 複数のイスをまとめて購入する
+// すべてのイスの在庫が足りる場合のみ在庫を減らし、1つでも足りなければ何も購入せずにイスごとのエラーを返す
+func buyChairs(c echo.Context) error {
+	var req BuyChairsRequest
+	if err := c.Bind(&req); err != nil {
+		c.Echo().Logger.Infof("post buy chairs failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if req.Email == "" {
+		c.Echo().Logger.Info("post buy chairs failed : email not found in request body")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	// 同じイスが複数回指定された場合は数量を合算する
+	quantities := map[int64]int64{}
+	for _, item := range req.Items {
+		if item.Quantity <= 0 {
+			c.Echo().Logger.Infof("post buy chairs failed : invalid quantity %v for chair id \"%v\"", item.Quantity, item.ID)
+			return c.NoContent(http.StatusBadRequest)
+		}
+		quantities[item.ID] += item.Quantity
+	}
+	if len(quantities) == 0 || len(quantities) > MaxCartItems {
+		c.Echo().Logger.Infof("post buy chairs failed : invalid number of items %v", len(quantities))
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	// デッドロックを避けるため、常に id の昇順で行ロックを取る
+	items := make([]CartItem, 0, len(quantities))
+	for id, quantity := range quantities {
+		items = append(items, CartItem{ID: id, Quantity: quantity})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	chairs := make([]Chair, 0, len(items))
+	itemErrors := []CartItemError{}
+	for _, item := range items {
+		var chair Chair
+		err = tx.QueryRowx("SELECT "+chairColumns+" FROM chair WHERE id = ? FOR UPDATE", item.ID).StructScan(&chair)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				itemErrors = append(itemErrors, CartItemError{ID: item.ID, Quantity: item.Quantity, Reason: CartItemErrorNotFound})
+				continue
+			}
+			c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if chair.Stock < item.Quantity {
+			itemErrors = append(itemErrors, CartItemError{ID: item.ID, Quantity: item.Quantity, Stock: chair.Stock, Reason: CartItemErrorInsufficientStock})
+			continue
+		}
+		chairs = append(chairs, chair)
+	}
+	if len(itemErrors) > 0 {
+		c.Echo().Logger.Infof("buyChairs failed : %v items unavailable", len(itemErrors))
+		return c.JSON(http.StatusConflict, BuyChairsErrorResponse{Errors: itemErrors})
+	}
+
+	var bought uint64
+	for i, item := range items {
+		_, err = tx.Exec("UPDATE chair SET stock = stock - ? WHERE id = ?", item.Quantity, item.ID)
+		if err != nil {
+			c.Echo().Logger.Errorf("chair stock update failed : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		_, err = tx.Exec("INSERT INTO chair_order(chair_id, quantity, email) VALUES(?, ?, ?)", item.ID, item.Quantity, req.Email)
+		if err != nil {
+			c.Echo().Logger.Errorf("chair order insert failed : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		chairs[i].Stock -= item.Quantity
+		bought += uint64(item.Quantity)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	chairsBought.Add(bought)
+
+	// 在庫は一覧のレスポンスに含まれないため、売り切れた場合のみ影響する
+	soldOut := []Chair{}
+	for _, chair := range chairs {
+		if chair.Stock <= 0 {
+			soldOut = append(soldOut, chair)
+		}
+	}
+	if len(soldOut) > 0 {
+		invalidateLowPricedChairIfAffected(soldOut)
+	}
+
+	return c.JSON(http.StatusOK, BuyChairsResponse{Items: items})
+}
+
 func getChairSearchCondition(c echo.Context) error {
 	return c.JSON(http.StatusOK, chairSearchCondition)
 }
 
 func getLowPricedChair(c echo.Context) error {
+	v, gen, ok := lowPricedChairCache.Get()
+	if ok {
+		return c.JSON(http.StatusOK, ChairListResponse{Chairs: v.([]Chair)})
+	}
+
 	var chairs []Chair
-	query := `SELECT * FROM chair WHERE stock > 0 ORDER BY price ASC, id ASC LIMIT ?`
+	query := `SELECT ` + chairColumns + ` FROM chair WHERE stock > 0 ORDER BY price ASC, id ASC LIMIT ?`
 	err := db.Select(&chairs, query, Limit)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -598,10 +1056,36 @@ func getLowPricedChair(c echo.Context) error {
 		c.Logger().Errorf("getLowPricedChair DB execution error : %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	lowPricedChairCache.Set(gen, chairs)
 
 	return c.JSON(http.StatusOK, ChairListResponse{Chairs: chairs})
 }
 
+// invalidateLowPricedChairIfAffected 追加・在庫変更されたイスが安価なイス一覧の内容を変える場合にキャッシュを破棄する
+func invalidateLowPricedChairIfAffected(changed []Chair) {
+	lowPricedChairCache.InvalidateIf(func(v interface{}) bool {
+		chairs := v.([]Chair)
+		for _, ch := range changed {
+			for _, cached := range chairs {
+				if cached.ID == ch.ID {
+					return true
+				}
+			}
+			if ch.Stock <= 0 {
+				continue
+			}
+			if len(chairs) < Limit {
+				return true
+			}
+			last := chairs[len(chairs)-1]
+			if ch.Price < last.Price || (ch.Price == last.Price && ch.ID < last.ID) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 func getEstateDetail(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -610,7 +1094,7 @@ func getEstateDetail(c echo.Context) error {
 	}
 
 	var estate Estate
-	err = db.Get(&estate, "SELECT * FROM estate WHERE id = ?", id)
+	err = db.Get(&estate, "SELECT "+estateColumns+" FROM estate WHERE id = ?", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.Echo().Logger.Infof("getEstateDetail estate id %v not found", id)
@@ -636,132 +1120,204 @@ func getRange(cond RangeCondition, rangeID string) (*Range, error) {
 	return cond.Ranges[RangeIndex], nil
 }
 
-func postEstate(c echo.Context) error {
-	header, err := c.FormFile("estates")
+// getRangeID value が含まれる Range の ID を返す。どの Range にも含まれない場合は -1
+func getRangeID(cond RangeCondition, value int64) int64 {
+	for _, r := range cond.Ranges {
+		if (r.Min == -1 || r.Min <= value) && (r.Max == -1 || value < r.Max) {
+			return r.ID
+		}
+	}
+	return -1
+}
+
+// rangeIDCase column の値から getRangeID と同じ結果を求める CASE 式を返す
+func rangeIDCase(cond RangeCondition, column string) string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for _, r := range cond.Ranges {
+		preds := []string{}
+		if r.Min != -1 {
+			preds = append(preds, fmt.Sprintf("%s >= %d", column, r.Min))
+		}
+		if r.Max != -1 {
+			preds = append(preds, fmt.Sprintf("%s < %d", column, r.Max))
+		}
+		if len(preds) == 0 {
+			preds = append(preds, "TRUE")
+		}
+		fmt.Fprintf(&b, " WHEN %s THEN %d", strings.Join(preds, " AND "), r.ID)
+	}
+	b.WriteString(" ELSE -1 END")
+	return b.String()
+}
+
+// keywordMatch name, description に対する FULLTEXT INDEX (ngram) を使ったキーワード検索の条件
+// 並び替えにも同じ式を使い、関連度の高い順に返す
+const keywordMatch = "MATCH (name, description) AGAINST (? IN NATURAL LANGUAGE MODE)"
+
+// searchCursor 検索結果の並び順 (popularity DESC, id ASC) で最後に返した行の位置
+type searchCursor struct {
+	Popularity int64
+	ID         int64
+}
+
+// cursorCondition searchCursor より後ろの行に絞り込む条件
+const cursorCondition = "(popularity < ? OR (popularity = ? AND id > ?))"
+
+func encodeSearchCursor(cur searchCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", cur.Popularity, cur.ID)))
+}
+
+func decodeSearchCursor(s string) (*searchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
 	if err != nil {
-		c.Logger().Errorf("failed to get form file: %v", err)
-		return c.NoContent(http.StatusBadRequest)
+		return nil, err
 	}
-	f, err := header.Open()
+
+	fields := strings.Split(string(b), ":")
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("Unexpected cursor")
+	}
+	popularity, err := strconv.ParseInt(fields[0], 10, 64)
 	if err != nil {
-		c.Logger().Errorf("failed to open form file: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, err
 	}
-	defer f.Close()
-	records, err := csv.NewReader(f).ReadAll()
+	id, err := strconv.ParseInt(fields[1], 10, 64)
 	if err != nil {
-		c.Logger().Errorf("failed to read csv: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, err
 	}
 
-	tx, err := db.Begin()
+	return &searchCursor{Popularity: popularity, ID: id}, nil
+}
+
+func postEstate(c echo.Context) error {
+	opt, ok := ingestOptions(c)
+	if !ok {
+		c.Echo().Logger.Infof("post estate failed : invalid options \"%v\"", c.QueryString())
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	format := uploadFormat(c)
+	var body io.Reader = c.Request().Body
+	if format == uploadFormatCSV {
+		part, err := uploadPart(c, "estates")
+		if err != nil {
+			c.Logger().Errorf("failed to get form file: %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
+		body = part
+	}
+
+	tx, err := db.Beginx()
 	if err != nil {
 		c.Logger().Errorf("failed to begin tx: %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 	defer tx.Rollback()
-	for _, row := range records {
-		rm := RecordMapper{Record: row}
-		id := rm.NextInt()
-		name := rm.NextString()
-		description := rm.NextString()
-		thumbnail := rm.NextString()
-		address := rm.NextString()
-		latitude := rm.NextFloat()
-		longitude := rm.NextFloat()
-		rent := rm.NextInt()
-		doorHeight := rm.NextInt()
-		doorWidth := rm.NextInt()
-		features := rm.NextString()
-		popularity := rm.NextInt()
-		if err := rm.Err(); err != nil {
-			c.Logger().Errorf("failed to read record: %v", err)
-			return c.NoContent(http.StatusBadRequest)
-		}
-		_, err := tx.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)", id, name, description, thumbnail, address, latitude, longitude, rent, doorHeight, doorWidth, features, popularity)
-		if err != nil {
-			c.Logger().Errorf("failed to insert estate: %v", err)
-			return c.NoContent(http.StatusInternalServerError)
+
+	in := newIngester(c.Request().Context(), tx, estateIngestTable(), opt)
+	// 安価な物件一覧に入るかは最も安い物件だけで決まるので、読んだ物件は保持せず最安のものだけ覚えておく
+	cheapest := []Estate{}
+	err = readEstates(format, body, func(line int, estate Estate) error {
+		if len(cheapest) == 0 || estate.Rent < cheapest[0].Rent || (estate.Rent == cheapest[0].Rent && estate.ID < cheapest[0].ID) {
+			cheapest = []Estate{{ID: estate.ID, Rent: estate.Rent}}
 		}
+		return in.Add(estateIngestRow(line, estate))
+	}, in.Reject)
+	if err == nil {
+		err = in.Flush()
 	}
-	if err := tx.Commit(); err != nil {
-		c.Logger().Errorf("failed to commit tx: %v", err)
-		return c.NoContent(http.StatusInternalServerError)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return respondIngest(c, in, err)
 	}
-	return c.NoContent(http.StatusCreated)
+	estateRowsInserted.Add(uint64(in.report.Inserted))
+	// 上書きした物件が一覧にあった場合、賃料が上がっていても気付けないためキャッシュを捨てる
+	if in.report.Updated > 0 {
+		lowPricedEstateCache.Invalidate()
+	}
+	invalidateLowPricedEstateIfAffected(cheapest)
+	return respondIngest(c, in, nil)
 }
 
-func searchEstates(c echo.Context) error {
-	conditions := make([]string, 0)
-	params := make([]interface{}, 0)
+// estateSearchFilters 賃料・ドアの幅と高さ・特徴の検索条件を組み立てる
+func estateSearchFilters(c echo.Context) (searchFilters, error) {
+	filters := searchFilters{}
 
 	if c.QueryParam("doorHeightRangeId") != "" {
 		doorHeight, err := getRange(estateSearchCondition.DoorHeight, c.QueryParam("doorHeightRangeId"))
 		if err != nil {
-			c.Echo().Logger.Infof("doorHeightRangeID invalid, %v : %v", c.QueryParam("doorHeightRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, fmt.Errorf("doorHeightRangeID invalid, %v : %v", c.QueryParam("doorHeightRangeId"), err)
 		}
 
-		if doorHeight.Min != -1 {
-			conditions = append(conditions, "door_height >= ?")
-			params = append(params, doorHeight.Min)
-		}
-		if doorHeight.Max != -1 {
-			conditions = append(conditions, "door_height < ?")
-			params = append(params, doorHeight.Max)
-		}
+		filters.add("doorHeight", "door_height_range_id = ?", doorHeight.ID)
 	}
 
 	if c.QueryParam("doorWidthRangeId") != "" {
 		doorWidth, err := getRange(estateSearchCondition.DoorWidth, c.QueryParam("doorWidthRangeId"))
 		if err != nil {
-			c.Echo().Logger.Infof("doorWidthRangeID invalid, %v : %v", c.QueryParam("doorWidthRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, fmt.Errorf("doorWidthRangeID invalid, %v : %v", c.QueryParam("doorWidthRangeId"), err)
 		}
 
-		if doorWidth.Min != -1 {
-			conditions = append(conditions, "door_width >= ?")
-			params = append(params, doorWidth.Min)
-		}
-		if doorWidth.Max != -1 {
-			conditions = append(conditions, "door_width < ?")
-			params = append(params, doorWidth.Max)
-		}
+		filters.add("doorWidth", "door_width_range_id = ?", doorWidth.ID)
 	}
 
 	if c.QueryParam("rentRangeId") != "" {
 		estateRent, err := getRange(estateSearchCondition.Rent, c.QueryParam("rentRangeId"))
 		if err != nil {
-			c.Echo().Logger.Infof("rentRangeID invalid, %v : %v", c.QueryParam("rentRangeId"), err)
-			return c.NoContent(http.StatusBadRequest)
+			return nil, fmt.Errorf("rentRangeID invalid, %v : %v", c.QueryParam("rentRangeId"), err)
 		}
 
-		if estateRent.Min != -1 {
-			conditions = append(conditions, "rent >= ?")
-			params = append(params, estateRent.Min)
-		}
-		if estateRent.Max != -1 {
-			conditions = append(conditions, "rent < ?")
-			params = append(params, estateRent.Max)
-		}
+		filters.add("rent", "rent_range_id = ?", estateRent.ID)
 	}
 
 	if c.QueryParam("features") != "" {
-		for _, f := range strings.Split(c.QueryParam("features"), ",") {
-			conditions = append(conditions, "features like concat('%', ?, '%')")
-			params = append(params, f)
-		}
+		cond, featureParams := featureCondition("estate", estateFeatureIDs, splitFeatures(c.QueryParam("features")))
+		filters.add("", cond, featureParams...)
+	}
+
+	return filters, nil
+}
+
+func searchEstates(c echo.Context) error {
+	filters, err := estateSearchFilters(c)
+	if err != nil {
+		c.Echo().Logger.Infof("searchEstates invalid condition : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	keyword := c.QueryParam("keyword")
+	if keyword != "" {
+		filters.add("", keywordMatch, keyword)
 	}
 
-	if len(conditions) == 0 {
+	if len(filters) == 0 {
 		c.Echo().Logger.Infof("searchEstates search condition not found")
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	page, err := strconv.Atoi(c.QueryParam("page"))
-	if err != nil {
-		c.Logger().Infof("Invalid format page parameter : %v", err)
+	// cursor が指定された場合は page の代わりに前ページ末尾の (popularity, id) から続きを取得する
+	var cursor *searchCursor
+	page := 0
+	if c.QueryParam("cursor") != "" && keyword != "" {
+		c.Logger().Infof("cursor parameter cannot be used with keyword")
 		return c.NoContent(http.StatusBadRequest)
+	} else if c.QueryParam("cursor") != "" {
+		var err error
+		cursor, err = decodeSearchCursor(c.QueryParam("cursor"))
+		if err != nil {
+			c.Logger().Infof("Invalid format cursor parameter : %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
+	} else {
+		var err error
+		page, err = strconv.Atoi(c.QueryParam("page"))
+		if err != nil {
+			c.Logger().Infof("Invalid format page parameter : %v", err)
+			return c.NoContent(http.StatusBadRequest)
+		}
 	}
 
 	perPage, err := strconv.Atoi(c.QueryParam("perPage"))
@@ -770,10 +1326,17 @@ func searchEstates(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	searchQuery := "SELECT * FROM estate WHERE "
+	withFacets, err := facetsRequested(c.QueryParam("facets"))
+	if err != nil {
+		c.Logger().Infof("Invalid format facets parameter : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	searchQuery := "SELECT " + estateColumns + " FROM estate WHERE "
 	countQuery := "SELECT COUNT(*) FROM estate WHERE "
-	searchCondition := strings.Join(conditions, " AND ")
-	limitOffset := " ORDER BY popularity DESC, id ASC LIMIT ? OFFSET ?"
+	searchCondition, params := filters.where("")
+	orderBy := " ORDER BY popularity DESC, id ASC"
+	limitOffset := " LIMIT ? OFFSET ?"
 
 	var res EstateSearchResponse
 	err = db.Get(&res.Count, countQuery+searchCondition, params...)
@@ -782,9 +1345,28 @@ func searchEstates(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if withFacets {
+		res.Facets, err = countFacets("estate", filters, estateFacetColumns(), estateSearchCondition.Feature)
+		if err != nil {
+			c.Logger().Errorf("searchEstates facets DB execution error : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if cursor != nil {
+		searchCondition += " AND " + cursorCondition
+		params = append(params, cursor.Popularity, cursor.Popularity, cursor.ID)
+	}
+
+	if keyword != "" {
+		orderBy = " ORDER BY " + keywordMatch + " DESC, popularity DESC, id ASC"
+		params = append(params, keyword)
+	}
+
+	// 次のページの有無を判定するため1件多く取得する
 	estates := []Estate{}
-	params = append(params, perPage, page*perPage)
-	err = db.Select(&estates, searchQuery+searchCondition+limitOffset, params...)
+	params = append(params, perPage+1, page*perPage)
+	err = db.Select(&estates, searchQuery+searchCondition+orderBy+limitOffset, params...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.JSON(http.StatusOK, EstateSearchResponse{Count: 0, Estates: []Estate{}})
@@ -793,14 +1375,28 @@ func searchEstates(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	if perPage >= 0 && len(estates) > perPage {
+		estates = estates[:perPage]
+		// keyword 指定時は関連度順に並ぶため cursor は発行しない
+		if perPage > 0 && keyword == "" {
+			last := estates[perPage-1]
+			res.NextCursor = encodeSearchCursor(searchCursor{Popularity: last.Popularity, ID: last.ID})
+		}
+	}
+
 	res.Estates = estates
 
 	return c.JSON(http.StatusOK, res)
 }
 
 func getLowPricedEstate(c echo.Context) error {
+	v, gen, ok := lowPricedEstateCache.Get()
+	if ok {
+		return c.JSON(http.StatusOK, EstateListResponse{Estates: v.([]Estate)})
+	}
+
 	estates := make([]Estate, 0, Limit)
-	query := `SELECT * FROM estate ORDER BY rent ASC, id ASC LIMIT ?`
+	query := `SELECT ` + estateColumns + ` FROM estate ORDER BY rent ASC, id ASC LIMIT ?`
 	err := db.Select(&estates, query, Limit)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -810,10 +1406,28 @@ func getLowPricedEstate(c echo.Context) error {
 		c.Logger().Errorf("getLowPricedEstate DB execution error : %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
+	lowPricedEstateCache.Set(gen, estates)
 
 	return c.JSON(http.StatusOK, EstateListResponse{Estates: estates})
 }
 
+// invalidateLowPricedEstateIfAffected 追加された物件が安価な物件一覧に入る場合にキャッシュを破棄する
+func invalidateLowPricedEstateIfAffected(added []Estate) {
+	lowPricedEstateCache.InvalidateIf(func(v interface{}) bool {
+		estates := v.([]Estate)
+		if len(estates) < Limit {
+			return len(added) > 0
+		}
+		last := estates[len(estates)-1]
+		for _, e := range added {
+			if e.Rent < last.Rent || (e.Rent == last.Rent && e.ID < last.ID) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
 func searchRecommendedEstateWithChair(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -822,7 +1436,7 @@ func searchRecommendedEstateWithChair(c echo.Context) error {
 	}
 
 	chair := Chair{}
-	query := `SELECT * FROM chair WHERE id = ?`
+	query := `SELECT ` + chairColumns + ` FROM chair WHERE id = ?`
 	err = db.Get(&chair, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -833,11 +1447,21 @@ func searchRecommendedEstateWithChair(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	strategy := c.QueryParam("strategy")
+	switch strategy {
+	case "", RecommendStrategyPopularity:
+	case RecommendStrategyScore:
+		return searchRankedEstateWithChair(c, chair)
+	default:
+		c.Logger().Infof("Invalid recommendation strategy : %v", strategy)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
 	var estates []Estate
 	w := chair.Width
 	h := chair.Height
 	d := chair.Depth
-	query = `SELECT * FROM estate WHERE (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) ORDER BY popularity DESC, id ASC LIMIT ?`
+	query = `SELECT ` + estateColumns + ` FROM estate WHERE ` + doorFitCondition + ` ORDER BY popularity DESC, id ASC LIMIT ?`
 	err = db.Select(&estates, query, w, h, w, d, h, w, h, d, d, w, d, h, Limit)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -858,53 +1482,62 @@ func searchEstateNazotte(c echo.Context) error {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	if len(coordinates.Coordinates) == 0 {
-		return c.NoContent(http.StatusBadRequest)
+	polygons, err := coordinates.toMultiPolygon()
+	if err != nil {
+		c.Echo().Logger.Infof("post search estate nazotte failed : %v", err)
+		return c.JSON(http.StatusBadRequest, NazotteErrorResponse{Reason: err.Error()})
 	}
 
-	b := coordinates.getBoundingBox()
-	estatesInBoundingBox := []Estate{}
-	query := `SELECT * FROM estate WHERE latitude <= ? AND latitude >= ? AND longitude <= ? AND longitude >= ? ORDER BY popularity DESC, id ASC`
-	err = db.Select(&estatesInBoundingBox, query, b.BottomRightCorner.Latitude, b.TopLeftCorner.Latitude, b.BottomRightCorner.Longitude, b.TopLeftCorner.Longitude)
+	var estatesInPolygon []Estate
+	if nazotteInGo {
+		estatesInPolygon, err = searchEstatesInPolygonInGo(polygons)
+	} else {
+		estatesInPolygon, err = searchEstatesInPolygonInMySQL(polygons)
+	}
 	if err == sql.ErrNoRows {
-		c.Echo().Logger.Infof("select * from estate where latitude ...", err)
 		return c.JSON(http.StatusOK, EstateSearchResponse{Count: 0, Estates: []Estate{}})
 	} else if err != nil {
 		c.Echo().Logger.Errorf("database execution error : %v", err)
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	estatesInPolygon := []Estate{}
-	for _, estate := range estatesInBoundingBox {
-		validatedEstate := Estate{}
-
-		point := fmt.Sprintf("'POINT(%f %f)'", estate.Latitude, estate.Longitude)
-		query := fmt.Sprintf(`SELECT * FROM estate WHERE id = ? AND ST_Contains(ST_PolygonFromText(%s), ST_GeomFromText(%s))`, coordinates.coordinatesToText(), point)
-		err = db.Get(&validatedEstate, query, estate.ID)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				continue
-			} else {
-				c.Echo().Logger.Errorf("db access is failed on executing validate if estate is in polygon : %v", err)
-				return c.NoContent(http.StatusInternalServerError)
-			}
-		} else {
-			estatesInPolygon = append(estatesInPolygon, validatedEstate)
-		}
-	}
-
 	var re EstateSearchResponse
-	re.Estates = []Estate{}
-	if len(estatesInPolygon) > NazotteLimit {
-		re.Estates = estatesInPolygon[:NazotteLimit]
-	} else {
-		re.Estates = estatesInPolygon
-	}
+	re.Estates = estatesInPolygon
 	re.Count = int64(len(re.Estates))
 
 	return c.JSON(http.StatusOK, re)
 }
 
+// searchEstatesInPolygonInMySQL point カラムの SPATIAL INDEX で外接矩形に絞り込んだ上で ST_Contains を評価する
+func searchEstatesInPolygonInMySQL(polygons geometry.MultiPolygon) ([]Estate, error) {
+	estates := []Estate{}
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE ST_Contains(ST_GeomFromText(?), point) ORDER BY popularity DESC, id ASC LIMIT ?`
+	err := db.Select(&estates, query, multiPolygonToText(polygons), NazotteLimit)
+	return estates, err
+}
+
+// searchEstatesInPolygonInGo 外接矩形内の物件を取得し、多角形の内外判定はアプリケーション側で行う
+func searchEstatesInPolygonInGo(polygons geometry.MultiPolygon) ([]Estate, error) {
+	b := polygons.BoundingBox()
+	estatesInBoundingBox := []Estate{}
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE latitude <= ? AND latitude >= ? AND longitude <= ? AND longitude >= ? ORDER BY popularity DESC, id ASC`
+	err := db.Select(&estatesInBoundingBox, query, b.Max.X, b.Min.X, b.Max.Y, b.Min.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	estates := []Estate{}
+	for _, estate := range estatesInBoundingBox {
+		if len(estates) >= NazotteLimit {
+			break
+		}
+		if polygons.Contains(geometry.Point{X: estate.Latitude, Y: estate.Longitude}) {
+			estates = append(estates, estate)
+		}
+	}
+	return estates, nil
+}
+
 func postEstateRequestDocument(c echo.Context) error {
 	m := echo.Map{}
 	if err := c.Bind(&m); err != nil {
@@ -912,21 +1545,34 @@ func postEstateRequestDocument(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	_, ok := m["email"].(string)
+	email, ok := m["email"].(string)
 	if !ok {
 		c.Echo().Logger.Info("post request document failed : email not found in request body")
 		return c.NoContent(http.StatusBadRequest)
 	}
 
+	key, ok := idempotencyKey(c)
+	if !ok {
+		c.Echo().Logger.Info("post request document failed : idempotency key too long")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Echo().Logger.Infof("post request document failed : %v", err)
 		return c.NoContent(http.StatusBadRequest)
 	}
 
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
 	estate := Estate{}
-	query := `SELECT * FROM estate WHERE id = ?`
-	err = db.Get(&estate, query, id)
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE id = ?`
+	err = tx.Get(&estate, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return c.NoContent(http.StatusNotFound)
@@ -935,6 +1581,22 @@ func postEstateRequestDocument(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	// 同じ申込者が同じキーで申し込み済みなら一意制約 (email, idempotency_key) に違反する
+	_, err = tx.Exec("INSERT INTO estate_document_request(estate_id, email, idempotency_key) VALUES(?, ?, ?)", id, email, key)
+	if err != nil {
+		if isDuplicateEntry(err) {
+			c.Echo().Logger.Infof("postEstateRequestDocument duplicated idempotency key \"%v\" for \"%v\"", key.String, email)
+			return c.NoContent(http.StatusConflict)
+		}
+		c.Logger().Errorf("estate document request insert failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	documentsRequested.Inc()
 	return c.NoContent(http.StatusOK)
 }
 
@@ -942,38 +1604,10 @@ func getEstateSearchCondition(c echo.Context) error {
 	return c.JSON(http.StatusOK, estateSearchCondition)
 }
 
-func (cs Coordinates) getBoundingBox() BoundingBox {
-	coordinates := cs.Coordinates
-	boundingBox := BoundingBox{
-		TopLeftCorner: Coordinate{
-			Latitude: coordinates[0].Latitude, Longitude: coordinates[0].Longitude,
-		},
-		BottomRightCorner: Coordinate{
-			Latitude: coordinates[0].Latitude, Longitude: coordinates[0].Longitude,
-		},
-	}
-	for _, coordinate := range coordinates {
-		if boundingBox.TopLeftCorner.Latitude > coordinate.Latitude {
-			boundingBox.TopLeftCorner.Latitude = coordinate.Latitude
-		}
-		if boundingBox.TopLeftCorner.Longitude > coordinate.Longitude {
-			boundingBox.TopLeftCorner.Longitude = coordinate.Longitude
-		}
-
-		if boundingBox.BottomRightCorner.Latitude < coordinate.Latitude {
-			boundingBox.BottomRightCorner.Latitude = coordinate.Latitude
-		}
-		if boundingBox.BottomRightCorner.Longitude < coordinate.Longitude {
-			boundingBox.BottomRightCorner.Longitude = coordinate.Longitude
-		}
-	}
-	return boundingBox
-}
-
 func (cs Coordinates) coordinatesToText() string {
 	points := make([]string, 0, len(cs.Coordinates))
 	for _, c := range cs.Coordinates {
 		points = append(points, fmt.Sprintf("%f %f", c.Latitude, c.Longitude))
 	}
-	return fmt.Sprintf("'POLYGON((%s))'", strings.Join(points, ","))
+	return fmt.Sprintf("POLYGON((%s))", strings.Join(points, ","))
 }
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// AccessLogEntry JSON で出力するアクセスログ1行分
+type AccessLogEntry struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	URI       string  `json:"uri"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	UserAgent string  `json:"user_agent"`
+	BytesOut  int64   `json:"bytes_out"`
+	RemoteIP  string  `json:"remote_ip"`
+}
+
+// UnmatchedRoute どのルートにもマッチしなかったリクエストのルート名
+const UnmatchedRoute = "-"
+
+// Route リクエストがマッチしたルートのテンプレート (例: /api/chair/:id) を返す
+func Route(c echo.Context) string {
+	if p := c.Path(); p != "" {
+		return p
+	}
+	return UnmatchedRoute
+}
+
+// AccessLog リクエストごとに JSON のアクセスログを out に書き出し、ルートごとのレイテンシを histograms に記録する
+func AccessLog(out io.Writer, histograms *RouteHistograms) echo.MiddlewareFunc {
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// ステータスコードを確定させるため、ここでエラーハンドラを呼び出す
+				c.Error(err)
+			}
+			latency := time.Since(start)
+
+			req := c.Request()
+			res := c.Response()
+			route := Route(c)
+			histograms.Observe(route, latency)
+
+			entry := AccessLogEntry{
+				Time:      start.Format(time.RFC3339Nano),
+				Method:    req.Method,
+				Route:     route,
+				URI:       req.RequestURI,
+				Status:    res.Status,
+				LatencyMs: float64(latency.Nanoseconds()) / float64(time.Millisecond),
+				UserAgent: req.UserAgent(),
+				BytesOut:  res.Size,
+				RemoteIP:  c.RealIP(),
+			}
+			mu.Lock()
+			enc.Encode(entry)
+			mu.Unlock()
+
+			return err
+		}
+	}
+}
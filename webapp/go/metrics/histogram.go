@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets レイテンシのヒストグラムのバケット上限 (秒)
+// ベンチマーカーのタイムアウト (2秒) とページ離脱の閾値 (1秒) を跨ぐように区切る
+var DefaultLatencyBuckets = []float64{
+	0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5,
+}
+
+// Histogram 観測値をバケットごとに数える
+type Histogram struct {
+	buckets []float64
+	// counts[i] は buckets[i] 以下の観測数 (累積ではない)、counts[len(buckets)] は上限を超えた観測数
+	counts []uint64
+	count  uint64
+	sumNs  uint64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	return &Histogram{
+		buckets: b,
+		counts:  make([]uint64, len(b)+1),
+	}
+}
+
+// Observe 経過時間を記録する
+func (h *Histogram) Observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sumNs, uint64(d.Nanoseconds()))
+}
+
+// Bucket 上限 UpperBound 以下の観測数の累積
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// HistogramSnapshot ある時点のヒストグラムの値
+type HistogramSnapshot struct {
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// Snapshot 現在の値を累積バケット形式で返す
+// 上限を超えた観測は Count にのみ含まれる
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadUint64(&h.sumNs)).Seconds(),
+		Buckets: make([]Bucket, 0, len(h.buckets)),
+	}
+	var cumulative uint64
+	for i, b := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		s.Buckets = append(s.Buckets, Bucket{UpperBound: b, Count: cumulative})
+	}
+	return s
+}
+
+// RouteHistograms ルートごとのレイテンシのヒストグラム
+type RouteHistograms struct {
+	buckets []float64
+
+	mu         sync.RWMutex
+	histograms map[string]*Histogram
+}
+
+func NewRouteHistograms(buckets []float64) *RouteHistograms {
+	return &RouteHistograms{
+		buckets:    buckets,
+		histograms: map[string]*Histogram{},
+	}
+}
+
+// Observe route のヒストグラムに経過時間を記録する
+func (r *RouteHistograms) Observe(route string, d time.Duration) {
+	r.mu.RLock()
+	h, ok := r.histograms[route]
+	r.mu.RUnlock()
+	if !ok {
+		r.mu.Lock()
+		h, ok = r.histograms[route]
+		if !ok {
+			h = NewHistogram(r.buckets)
+			r.histograms[route] = h
+		}
+		r.mu.Unlock()
+	}
+	h.Observe(d)
+}
+
+// Snapshot ルートごとの現在の値を返す
+func (r *RouteHistograms) Snapshot() map[string]HistogramSnapshot {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := make(map[string]HistogramSnapshot, len(r.histograms))
+	for route, h := range r.histograms {
+		res[route] = h.Snapshot()
+	}
+	return res
+}
@@ -0,0 +1,222 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labstack/echo"
+)
+
+// ContentType Prometheus のテキスト形式 (version 0.0.4) の Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Label メトリクスのラベル
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Counter 単調増加するカウンタ
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+type sample struct {
+	suffix string
+	labels []Label
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	collect []func() []sample
+}
+
+// Registry メトリクスを登録し、Prometheus のテキスト形式で出力する
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(name, help, typ string, collect func() []sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			f.collect = append(f.collect, collect)
+			return
+		}
+	}
+	r.families = append(r.families, &family{name: name, help: help, typ: typ, collect: []func() []sample{collect}})
+}
+
+// Counter カウンタを登録する
+// 同じ名前でラベルの異なるカウンタは1つのメトリクスとしてまとめて出力する
+func (r *Registry) Counter(name, help string, labels ...Label) *Counter {
+	c := &Counter{}
+	r.add(name, help, "counter", func() []sample {
+		return []sample{{labels: labels, value: float64(c.Value())}}
+	})
+	return c
+}
+
+// CounterFunc 出力のたびに f を呼び出して値を得るカウンタを登録する
+func (r *Registry) CounterFunc(name, help string, f func() float64, labels ...Label) {
+	r.add(name, help, "counter", func() []sample {
+		return []sample{{labels: labels, value: f()}}
+	})
+}
+
+// GaugeFunc 出力のたびに f を呼び出して値を得るゲージを登録する
+func (r *Registry) GaugeFunc(name, help string, f func() float64, labels ...Label) {
+	r.add(name, help, "gauge", func() []sample {
+		return []sample{{labels: labels, value: f()}}
+	})
+}
+
+// RouteHistograms ルートごとのヒストグラムを route ラベル付きで登録する
+func (r *Registry) RouteHistograms(name, help string, h *RouteHistograms) {
+	r.add(name, help, "histogram", func() []sample {
+		snapshots := h.Snapshot()
+		routes := make([]string, 0, len(snapshots))
+		for route := range snapshots {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+
+		samples := []sample{}
+		for _, route := range routes {
+			s := snapshots[route]
+			for _, b := range s.Buckets {
+				samples = append(samples, sample{
+					suffix: "_bucket",
+					labels: []Label{{"route", route}, {"le", formatFloat(b.UpperBound)}},
+					value:  float64(b.Count),
+				})
+			}
+			samples = append(samples,
+				sample{suffix: "_bucket", labels: []Label{{"route", route}, {"le", "+Inf"}}, value: float64(s.Count)},
+				sample{suffix: "_sum", labels: []Label{{"route", route}}, value: s.Sum},
+				sample{suffix: "_count", labels: []Label{{"route", route}}, value: float64(s.Count)},
+			)
+		}
+		return samples
+	})
+}
+
+// WriteTo 登録されたメトリクスを Prometheus のテキスト形式で書き出す
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, collect := range f.collect {
+			for _, s := range collect() {
+				cw.WriteString(f.name + s.suffix)
+				writeLabels(cw, s.labels)
+				cw.WriteString(" " + formatFloat(s.value) + "\n")
+			}
+		}
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+// Handler メトリクスを返す echo のハンドラ
+func (r *Registry) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, ContentType)
+		c.Response().WriteHeader(http.StatusOK)
+		_, err := r.WriteTo(c.Response())
+		return err
+	}
+}
+
+func writeLabels(w *countWriter, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteString("{")
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString(l.Name + `="` + escapeLabelValue(l.Value) + `"`)
+	}
+	w.WriteString("}")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+func (cw *countWriter) WriteString(s string) {
+	cw.Write([]byte(s))
+}
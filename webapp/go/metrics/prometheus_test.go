@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(.*)\})? (\S+)$`)
+var labelPair = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+
+type parsedSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseExposition Prometheus のテキスト形式をパースし、形式に沿わない行があればテストを失敗させる
+func parseExposition(t *testing.T, r io.Reader) (map[string]string, []parsedSample) {
+	t.Helper()
+	types := map[string]string{}
+	samples := []parsedSample{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			if len(fields) != 4 {
+				t.Fatalf("malformed TYPE line: %q", line)
+			}
+			if _, ok := types[fields[2]]; ok {
+				t.Fatalf("duplicated TYPE line: %q", line)
+			}
+			types[fields[2]] = fields[3]
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed sample line: %q", line)
+		}
+		v, err := strconv.ParseFloat(m[4], 64)
+		if err != nil {
+			t.Fatalf("malformed sample value: %q", line)
+		}
+		labels := map[string]string{}
+		for _, l := range labelPair.FindAllStringSubmatch(m[3], -1) {
+			labels[l[1]] = strings.NewReplacer(`\"`, `"`, `\n`, "\n", `\\`, `\`).Replace(l[2])
+		}
+		samples = append(samples, parsedSample{name: m[1], labels: labels, value: v})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return types, samples
+}
+
+func find(samples []parsedSample, name string, labels map[string]string) (float64, bool) {
+	for _, s := range samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if s.labels[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return s.value, true
+		}
+	}
+	return 0, false
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	bought := r.Counter("isuumo_chairs_bought_total", "Number of chairs bought.")
+	chairRows := r.Counter("isuumo_ingest_rows_inserted_total", "Number of rows inserted via bulk upload.", Label{"table", "chair"})
+	estateRows := r.Counter("isuumo_ingest_rows_inserted_total", "Number of rows inserted via bulk upload.", Label{"table", "estate"})
+	r.GaugeFunc("go_goroutines", "Number of goroutines.", func() float64 { return 7 })
+	r.CounterFunc("isuumo_db_wait_count_total", "Number of connections waited for.", func() float64 { return 3 })
+
+	h := NewRouteHistograms([]float64{0.01, 0.1})
+	r.RouteHistograms("isuumo_http_request_duration_seconds", "HTTP request latency by route.", h)
+
+	bought.Inc()
+	bought.Inc()
+	chairRows.Add(10)
+	estateRows.Add(5)
+	h.Observe("/api/chair/:id", 5*time.Millisecond)
+	h.Observe("/api/chair/:id", 50*time.Millisecond)
+	h.Observe("/api/chair/:id", time.Second)
+	h.Observe(`/a"b`, time.Millisecond)
+
+	e := echo.New()
+	e.GET("/metrics", r.Handler())
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %v", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+
+	types, samples := parseExposition(t, res.Body)
+
+	expectedTypes := map[string]string{
+		"isuumo_chairs_bought_total":           "counter",
+		"isuumo_ingest_rows_inserted_total":    "counter",
+		"go_goroutines":                        "gauge",
+		"isuumo_db_wait_count_total":           "counter",
+		"isuumo_http_request_duration_seconds": "histogram",
+	}
+	for name, typ := range expectedTypes {
+		if types[name] != typ {
+			t.Errorf("unexpected type of %v. expected: %v, but got: %v", name, typ, types[name])
+		}
+	}
+
+	route := "/api/chair/:id"
+	cases := []struct {
+		name   string
+		labels map[string]string
+		value  float64
+	}{
+		{"isuumo_chairs_bought_total", map[string]string{}, 2},
+		{"isuumo_ingest_rows_inserted_total", map[string]string{"table": "chair"}, 10},
+		{"isuumo_ingest_rows_inserted_total", map[string]string{"table": "estate"}, 5},
+		{"go_goroutines", map[string]string{}, 7},
+		{"isuumo_db_wait_count_total", map[string]string{}, 3},
+		{"isuumo_http_request_duration_seconds_bucket", map[string]string{"route": route, "le": "0.01"}, 1},
+		{"isuumo_http_request_duration_seconds_bucket", map[string]string{"route": route, "le": "0.1"}, 2},
+		{"isuumo_http_request_duration_seconds_bucket", map[string]string{"route": route, "le": "+Inf"}, 3},
+		{"isuumo_http_request_duration_seconds_sum", map[string]string{"route": route}, 1.055},
+		{"isuumo_http_request_duration_seconds_count", map[string]string{"route": route}, 3},
+		{"isuumo_http_request_duration_seconds_count", map[string]string{"route": `/a"b`}, 1},
+	}
+	for _, c := range cases {
+		v, ok := find(samples, c.name, c.labels)
+		if !ok {
+			t.Errorf("sample not found: %v%v", c.name, c.labels)
+			continue
+		}
+		if v != c.value {
+			t.Errorf("unexpected value of %v%v. expected: %v, but got: %v", c.name, c.labels, c.value, v)
+		}
+	}
+}
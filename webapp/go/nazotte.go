@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/isucon/isucon10-qualify/isuumo/geometry"
+)
+
+// MaxNazotteVertices なぞって検索で指定できる頂点の合計数の上限
+// 自己交差の検証は辺の数の2乗に比例するため制限する
+const MaxNazotteVertices = 1000
+
+func toRing(coordinates []Coordinate) (geometry.Polygon, error) {
+	ring := make(geometry.Polygon, 0, len(coordinates))
+	for _, c := range coordinates {
+		if math.IsNaN(c.Latitude) || c.Latitude < -90 || c.Latitude > 90 ||
+			math.IsNaN(c.Longitude) || c.Longitude < -180 || c.Longitude > 180 {
+			return nil, fmt.Errorf("coordinate out of range: (%v, %v)", c.Latitude, c.Longitude)
+		}
+		ring = append(ring, geometry.Point{X: c.Latitude, Y: c.Longitude})
+	}
+	return ring.Close(), nil
+}
+
+func (p NazottePolygon) toPolygon() (geometry.PolygonWithHoles, error) {
+	outer, err := toRing(p.Coordinates)
+	if err != nil {
+		return geometry.PolygonWithHoles{}, err
+	}
+	polygon := geometry.PolygonWithHoles{Outer: outer}
+	for _, h := range p.Holes {
+		hole, err := toRing(h)
+		if err != nil {
+			return geometry.PolygonWithHoles{}, err
+		}
+		polygon.Holes = append(polygon.Holes, hole)
+	}
+	return polygon, nil
+}
+
+// toMultiPolygon 範囲を多角形に変換し、閉じていないリングを閉じた上で検証する
+// エラーのメッセージはそのままレスポンスの reason になる
+func (cs Coordinates) toMultiPolygon() (geometry.MultiPolygon, error) {
+	polygons := cs.Polygons
+	if len(polygons) == 0 {
+		if len(cs.Coordinates) == 0 {
+			return nil, errors.New("coordinates or polygons is required")
+		}
+		polygons = []NazottePolygon{{Coordinates: cs.Coordinates, Holes: cs.Holes}}
+	} else if len(cs.Coordinates) > 0 || len(cs.Holes) > 0 {
+		return nil, errors.New("coordinates and polygons cannot be used together")
+	}
+
+	vertices := 0
+	for _, p := range polygons {
+		vertices += len(p.Coordinates)
+		for _, h := range p.Holes {
+			vertices += len(h)
+		}
+	}
+	if vertices > MaxNazotteVertices {
+		return nil, fmt.Errorf("too many vertices: %d (max %d)", vertices, MaxNazotteVertices)
+	}
+
+	m := make(geometry.MultiPolygon, 0, len(polygons))
+	for _, p := range polygons {
+		polygon, err := p.toPolygon()
+		if err != nil {
+			return nil, err
+		}
+		m = append(m, polygon)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func ringToText(ring geometry.Polygon) string {
+	points := make([]string, 0, len(ring))
+	for _, pt := range ring {
+		points = append(points, fmt.Sprintf("%f %f", pt.X, pt.Y))
+	}
+	return "(" + strings.Join(points, ",") + ")"
+}
+
+func polygonToText(p geometry.PolygonWithHoles) string {
+	rings := []string{ringToText(p.Outer)}
+	for _, h := range p.Holes {
+		rings = append(rings, ringToText(h))
+	}
+	return "(" + strings.Join(rings, ",") + ")"
+}
+
+// multiPolygonToText 多角形が1つなら POLYGON、複数なら MULTIPOLYGON の WKT にする
+func multiPolygonToText(m geometry.MultiPolygon) string {
+	if len(m) == 1 {
+		return "POLYGON" + polygonToText(m[0])
+	}
+	polygons := make([]string, 0, len(m))
+	for _, p := range m {
+		polygons = append(polygons, polygonToText(p))
+	}
+	return "MULTIPOLYGON(" + strings.Join(polygons, ",") + ")"
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/isucon/isucon10-qualify/isuumo/geometry"
+)
+
+func TestCoordinates_ToMultiPolygon(t *testing.T) {
+	square := []Coordinate{{0, 0}, {0, 10}, {10, 10}, {10, 0}}
+	hole := []Coordinate{{4, 4}, {4, 6}, {6, 6}, {6, 4}, {4, 4}}
+	triangle := []Coordinate{{20, 20}, {20, 30}, {30, 30}}
+
+	tests := []struct {
+		name string
+		cs   Coordinates
+		wkt  string
+	}{
+		{
+			name: "unclosed ring",
+			cs:   Coordinates{Coordinates: square},
+			wkt:  "POLYGON((0.000000 0.000000,0.000000 10.000000,10.000000 10.000000,10.000000 0.000000,0.000000 0.000000))",
+		},
+		{
+			name: "with hole",
+			cs:   Coordinates{Coordinates: square, Holes: [][]Coordinate{hole}},
+			wkt: "POLYGON((0.000000 0.000000,0.000000 10.000000,10.000000 10.000000,10.000000 0.000000,0.000000 0.000000)," +
+				"(4.000000 4.000000,4.000000 6.000000,6.000000 6.000000,6.000000 4.000000,4.000000 4.000000))",
+		},
+		{
+			name: "multiple polygons",
+			cs:   Coordinates{Polygons: []NazottePolygon{{Coordinates: square}, {Coordinates: triangle}}},
+			wkt: "MULTIPOLYGON(((0.000000 0.000000,0.000000 10.000000,10.000000 10.000000,10.000000 0.000000,0.000000 0.000000))," +
+				"((20.000000 20.000000,20.000000 30.000000,30.000000 30.000000,20.000000 20.000000)))",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.cs.toMultiPolygon()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := multiPolygonToText(m); got != tt.wkt {
+				t.Errorf("multiPolygonToText() = %v, want %v", got, tt.wkt)
+			}
+		})
+	}
+}
+
+func TestCoordinates_ToMultiPolygonInvalid(t *testing.T) {
+	square := []Coordinate{{0, 0}, {0, 10}, {10, 10}, {10, 0}}
+	tooMany := make([]Coordinate, MaxNazotteVertices+1)
+
+	tests := []struct {
+		name   string
+		cs     Coordinates
+		reason string
+	}{
+		{name: "empty", cs: Coordinates{}, reason: "coordinates or polygons is required"},
+		{name: "both", cs: Coordinates{Coordinates: square, Polygons: []NazottePolygon{{Coordinates: square}}}, reason: "coordinates and polygons cannot be used together"},
+		{name: "out of range", cs: Coordinates{Coordinates: []Coordinate{{0, 0}, {0, 10}, {91, 0}}}, reason: "coordinate out of range: (91, 0)"},
+		{name: "too many vertices", cs: Coordinates{Coordinates: tooMany}, reason: "too many vertices: 1001 (max 1000)"},
+		{name: "self-intersecting", cs: Coordinates{Coordinates: []Coordinate{{0, 0}, {10, 10}, {10, 0}, {0, 10}}}, reason: "outer ring is self-intersecting at edges 0 and 2"},
+		{name: "invalid polygon", cs: Coordinates{Polygons: []NazottePolygon{{Coordinates: square}, {Coordinates: square[:2]}}}, reason: "polygon 1: outer ring must have at least 3 distinct points"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cs.toMultiPolygon()
+			if err == nil || err.Error() != tt.reason {
+				t.Errorf("toMultiPolygon() error = %v, want %v", err, tt.reason)
+			}
+		})
+	}
+}
+
+// testdata/nazotte には、検証データの生成器が作らない穴あき・複数の多角形のリクエストと、
+// その内側・外側にある点を置いている
+func TestCoordinates_ToMultiPolygonFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "nazotte", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixture found in testdata/nazotte")
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fixture struct {
+				Body    Coordinates  `json:"body"`
+				Inside  []Coordinate `json:"inside"`
+				Outside []Coordinate `json:"outside"`
+			}
+			if err := json.Unmarshal(b, &fixture); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := fixture.Body.toMultiPolygon()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range fixture.Inside {
+				if !m.Contains(geometry.Point{X: c.Latitude, Y: c.Longitude}) {
+					t.Errorf("(%v, %v) should be inside", c.Latitude, c.Longitude)
+				}
+			}
+			for _, c := range fixture.Outside {
+				if m.Contains(geometry.Point{X: c.Latitude, Y: c.Longitude}) {
+					t.Errorf("(%v, %v) should be outside", c.Latitude, c.Longitude)
+				}
+			}
+		})
+	}
+}
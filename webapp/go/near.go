@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/isucon/isucon10-qualify/isuumo/geometry"
+)
+
+// MaxNearRadius 周辺検索で指定できる半径 (km) の上限
+// 物件は都市の周辺に集まっているため、大きな半径では多くの行を読むことになる
+const MaxNearRadius = 10.0
+
+// NearLimit 周辺検索で返す物件数の上限
+const NearLimit = 50
+
+// NearCandidateLimit 周辺検索で DB から読む物件数の上限
+// 近い順に読むので、NearLimit 件を返すには十分な数にしている
+const NearCandidateLimit = 1000
+
+type NearEstate struct {
+	Estate
+	// Distance 中心からの大円距離 (km)
+	Distance float64 `json:"distance"`
+}
+
+type EstateNearResponse struct {
+	Center  Coordinate   `json:"center"`
+	Radius  float64      `json:"radius"`
+	Count   int64        `json:"count"`
+	Estates []NearEstate `json:"estates"`
+}
+
+// parseNearQuery lat, lng, radius パラメータを検証する
+func parseNearQuery(c echo.Context) (Coordinate, float64, error) {
+	lat, err := strconv.ParseFloat(c.QueryParam("lat"), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return Coordinate{}, 0, fmt.Errorf("lat invalid, %v", c.QueryParam("lat"))
+	}
+	lng, err := strconv.ParseFloat(c.QueryParam("lng"), 64)
+	if err != nil || math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return Coordinate{}, 0, fmt.Errorf("lng invalid, %v", c.QueryParam("lng"))
+	}
+	radius, err := strconv.ParseFloat(c.QueryParam("radius"), 64)
+	if err != nil || math.IsNaN(radius) || radius <= 0 || radius > MaxNearRadius {
+		return Coordinate{}, 0, fmt.Errorf("radius invalid, %v (must be greater than 0 and at most %v)", c.QueryParam("radius"), MaxNearRadius)
+	}
+	return Coordinate{Latitude: lat, Longitude: lng}, radius, nil
+}
+
+// nearQuery 中心の周りの外接矩形内にある物件を、近い順に NearCandidateLimit 件まで読むクエリを返す
+// 並べ替えには経度を緯度の縮尺に合わせた平面上の距離の2乗を使う。正確な距離はアプリケーション側で計算する
+func nearQuery(center Coordinate, radius float64, filters searchFilters) (string, []interface{}) {
+	b := geometry.BoundingBoxAround(geometry.Point{X: center.Latitude, Y: center.Longitude}, radius)
+	box := Coordinates{Coordinates: []Coordinate{
+		{Latitude: b.Min.X, Longitude: b.Min.Y},
+		{Latitude: b.Min.X, Longitude: b.Max.Y},
+		{Latitude: b.Max.X, Longitude: b.Max.Y},
+		{Latitude: b.Max.X, Longitude: b.Min.Y},
+		{Latitude: b.Min.X, Longitude: b.Min.Y},
+	}}
+	filters.add("", "MBRContains(ST_PolygonFromText(?), point)", box.coordinatesToText())
+
+	where, params := filters.where("")
+	scale := math.Cos(center.Latitude * math.Pi / 180)
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE ` + where +
+		` ORDER BY POW(latitude - ?, 2) + POW((longitude - ?) * ?, 2) ASC, id ASC LIMIT ?`
+	params = append(params, center.Latitude, center.Longitude, scale, NearCandidateLimit)
+	return query, params
+}
+
+// searchEstatesNear 中心から半径 radius (km) 以内の物件を近い順に返す
+// 空間インデックスで外接矩形内に絞り込んで近い順に読み、正確な距離での絞り込みと並べ替えはアプリケーション側で行う
+// Count は読み込んだ物件のうち半径内にあるものの数なので、NearCandidateLimit を超えることはない
+func searchEstatesNear(c echo.Context) error {
+	center, radius, err := parseNearQuery(c)
+	if err != nil {
+		c.Echo().Logger.Infof("searchEstatesNear invalid query : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	filters, err := estateSearchFilters(c)
+	if err != nil {
+		c.Echo().Logger.Infof("searchEstatesNear invalid condition : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	query, params := nearQuery(center, radius, filters)
+	candidates := []Estate{}
+	err = db.Select(&candidates, query, params...)
+	if err != nil {
+		c.Logger().Errorf("searchEstatesNear DB execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	origin := geometry.Point{X: center.Latitude, Y: center.Longitude}
+	estates := []NearEstate{}
+	for _, e := range candidates {
+		d := geometry.Distance(origin, geometry.Point{X: e.Latitude, Y: e.Longitude})
+		if d <= radius {
+			estates = append(estates, NearEstate{Estate: e, Distance: d})
+		}
+	}
+	sort.Slice(estates, func(i, j int) bool {
+		if estates[i].Distance != estates[j].Distance {
+			return estates[i].Distance < estates[j].Distance
+		}
+		return estates[i].ID < estates[j].ID
+	})
+
+	res := EstateNearResponse{Center: center, Radius: radius, Count: int64(len(estates))}
+	if len(estates) > NearLimit {
+		estates = estates[:NearLimit]
+	}
+	res.Estates = estates
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func setupEstateSearchCondition(t *testing.T) {
+	t.Helper()
+	saved, savedIDs := estateSearchCondition, estateFeatureIDs
+	estateSearchCondition = EstateSearchCondition{
+		Rent: RangeCondition{Ranges: []*Range{
+			{ID: 0, Min: -1, Max: 50000},
+			{ID: 1, Min: 50000, Max: -1},
+		}},
+		Feature: ListCondition{List: []string{"バス・トイレ別", "駅から徒歩5分"}},
+	}
+	estateFeatureIDs = newFeatureIDs(estateSearchCondition.Feature)
+	t.Cleanup(func() {
+		estateSearchCondition, estateFeatureIDs = saved, savedIDs
+	})
+}
+
+// 不正なクエリは DB を読む前に 400 で拒否する
+func TestSearchEstatesNear_BadRequest(t *testing.T) {
+	setupEstateSearchCondition(t)
+
+	e := echo.New()
+	e.GET("/api/estate/near", searchEstatesNear)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{name: "zero radius", query: "lat=35.68&lng=139.76&radius=0"},
+		{name: "negative radius", query: "lat=35.68&lng=139.76&radius=-1"},
+		{name: "radius above the cap", query: "lat=35.68&lng=139.76&radius=10.5"},
+		{name: "NaN radius", query: "lat=35.68&lng=139.76&radius=NaN"},
+		{name: "NaN lat", query: "lat=NaN&lng=139.76&radius=1"},
+		{name: "lat out of range", query: "lat=91&lng=139.76&radius=1"},
+		{name: "missing lng", query: "lat=35.68&radius=1"},
+		{name: "invalid rent range", query: "lat=35.68&lng=139.76&radius=1&rentRangeId=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/estate/near?"+tt.query, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("unexpected status. expected: %v, but got: %v", http.StatusBadRequest, rec.Code)
+			}
+		})
+	}
+}
+
+// 賃料と特徴の条件は外接矩形の条件と組み合わせて、近い順に上限件数まで読む
+func TestNearQuery_WithFilters(t *testing.T) {
+	setupEstateSearchCondition(t)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/estate/near?lat=35.68&lng=139.76&radius=1&rentRangeId=1&features=駅から徒歩5分", nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	center, radius, err := parseNearQuery(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters, err := estateSearchFilters(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, params := nearQuery(center, radius, filters)
+
+	expectedQuery := "SELECT " + estateColumns + " FROM estate WHERE rent_range_id = ?" +
+		" AND id IN (SELECT estate_id FROM estate_feature WHERE feature_id IN (?) GROUP BY estate_id HAVING COUNT(*) = ?)" +
+		" AND MBRContains(ST_PolygonFromText(?), point)" +
+		" ORDER BY POW(latitude - ?, 2) + POW((longitude - ?) * ?, 2) ASC, id ASC LIMIT ?"
+	if query != expectedQuery {
+		t.Errorf("unexpected query.\nexpected: %v\nbut got:  %v", expectedQuery, query)
+	}
+
+	box := "POLYGON((35.671007 139.748927,35.671007 139.771073,35.688993 139.771073,35.688993 139.748927,35.671007 139.748927))"
+	expectedParams := []interface{}{int64(1), int64(1), 1, box, 35.68, 139.76, math.Cos(35.68 * math.Pi / 180), NearCandidateLimit}
+	if !reflect.DeepEqual(params, expectedParams) {
+		t.Errorf("unexpected params.\nexpected: %v\nbut got:  %v", expectedParams, params)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo"
+)
+
+// IdempotencyKeyHeader 同じ申し込みの再送を識別するためのヘッダ
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader 再送に対して保存済みの結果を返したことを示すヘッダ
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// MaxIdempotencyKeyLength chair_buy_idempotency, estate_document_request の idempotency_key カラムの長さ
+const MaxIdempotencyKeyLength = 128
+
+// mysqlErrDupEntry 一意制約に違反したときの MySQL のエラー番号
+const mysqlErrDupEntry = 1062
+
+// mysqlErrLockDeadlock デッドロックでトランザクションがロールバックされたときの MySQL のエラー番号
+const mysqlErrLockDeadlock = 1213
+
+// ChairOrder イスの購入履歴
+type ChairOrder struct {
+	ID        int64     `db:"id" json:"id"`
+	ChairID   int64     `db:"chair_id" json:"chairId"`
+	Quantity  int64     `db:"quantity" json:"quantity"`
+	Email     string    `db:"email" json:"email"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+type ChairOrderListResponse struct {
+	Orders []ChairOrder `json:"orders"`
+}
+
+// EstateDocumentRequest 物件の資料請求の履歴
+type EstateDocumentRequest struct {
+	ID        int64     `db:"id" json:"id"`
+	EstateID  int64     `db:"estate_id" json:"estateId"`
+	Email     string    `db:"email" json:"email"`
+	CreatedAt time.Time `db:"created_at" json:"createdAt"`
+}
+
+type EstateDocumentRequestListResponse struct {
+	DocumentRequests []EstateDocumentRequest `json:"documentRequests"`
+}
+
+// idempotencyKey リクエストの Idempotency-Key を返す。指定されていなければ NULL になる
+func idempotencyKey(c echo.Context) (sql.NullString, bool) {
+	key := c.Request().Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return sql.NullString{}, true
+	}
+	if len(key) > MaxIdempotencyKeyLength {
+		return sql.NullString{}, false
+	}
+	return sql.NullString{String: key, Valid: true}, true
+}
+
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == mysqlErrDupEntry
+}
+
+func isDeadlock(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == mysqlErrLockDeadlock
+}
+
+func getChairOrders(c echo.Context) error {
+	email := c.QueryParam("email")
+	if email == "" {
+		c.Echo().Logger.Info("get chair orders failed : email not found in query")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	orders := []ChairOrder{}
+	query := `SELECT id, chair_id, quantity, email, created_at FROM chair_order WHERE email = ? ORDER BY created_at DESC, id DESC`
+	if err := db.Select(&orders, query, email); err != nil {
+		c.Logger().Errorf("getChairOrders DB execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, ChairOrderListResponse{Orders: orders})
+}
+
+func getEstateDocumentRequests(c echo.Context) error {
+	email := c.QueryParam("email")
+	if email == "" {
+		c.Echo().Logger.Info("get document requests failed : email not found in query")
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	requests := []EstateDocumentRequest{}
+	query := `SELECT id, estate_id, email, created_at FROM estate_document_request WHERE email = ? ORDER BY created_at DESC, id DESC`
+	if err := db.Select(&requests, query, email); err != nil {
+		c.Logger().Errorf("getEstateDocumentRequests DB execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, EstateDocumentRequestListResponse{DocumentRequests: requests})
+}
+
+// commitBuyChairStatus Idempotency-Key が指定されていれば返すステータスコードを記録してからコミットする
+func commitBuyChairStatus(tx *sqlx.Tx, email string, key sql.NullString, status int) error {
+	if key.Valid {
+		_, err := tx.Exec("UPDATE chair_buy_idempotency SET status = ? WHERE email = ? AND idempotency_key = ?", status, email, key)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// replayBuyChair 同じ購入者が同じ Idempotency-Key で最初に返したステータスコードを返す
+// 別のイスの購入に同じキーが使われた場合は 409 を返す
+func replayBuyChair(c echo.Context, email, key string, chairID int64) error {
+	var record struct {
+		ChairID int64 `db:"chair_id"`
+		Status  int   `db:"status"`
+	}
+	err := db.Get(&record, "SELECT chair_id, status FROM chair_buy_idempotency WHERE email = ? AND idempotency_key = ?", email, key)
+	if err != nil {
+		c.Echo().Logger.Errorf("replayBuyChair DB execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if record.ChairID != chairID {
+		c.Echo().Logger.Infof("buyChair idempotency key \"%v\" reused for chair id \"%v\"", key, chairID)
+		return c.NoContent(http.StatusConflict)
+	}
+
+	c.Response().Header().Set(IdempotentReplayedHeader, "true")
+	return c.NoContent(record.Status)
+}
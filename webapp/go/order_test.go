@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// Idempotency-Key は申込者ごとに区別されるので、別の申込者が同じキーを使っても重複とはみなさない
+func TestPostEstateRequestDocument_IdempotencyKeyPerRequester(t *testing.T) {
+	setupTestDB(t)
+
+	key := fmt.Sprintf("document-request-test-%d", time.Now().UnixNano())
+	cleanup := func() {
+		db.Exec("DELETE FROM estate WHERE id = ?", testEstateID)
+		db.Exec("DELETE FROM estate_document_request WHERE estate_id = ?", testEstateID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	_, err := db.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+		testEstateID, "test", "test", "/images/estate/test.png", "東京都", 35.681236, 139.767125, 100000, 100, 100, "", 0)
+	if err != nil {
+		t.Fatal("failed to insert estate:", err)
+	}
+
+	e := echo.New()
+	e.POST("/api/estate/req_doc/:id", postEstateRequestDocument)
+
+	tests := []struct {
+		email string
+		want  int
+	}{
+		{email: "a@example.com", want: http.StatusOK},
+		{email: "b@example.com", want: http.StatusOK},
+		{email: "a@example.com", want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/estate/req_doc/%d", testEstateID), strings.NewReader(fmt.Sprintf(`{"email":%q}`, tt.email)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%v: unexpected status. expected: %v, but got: %v", tt.email, tt.want, rec.Code)
+		}
+	}
+
+	var requests int
+	if err := db.Get(&requests, "SELECT COUNT(*) FROM estate_document_request WHERE estate_id = ?", testEstateID); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("unexpected number of document requests. expected: 2, but got: %v", requests)
+	}
+}
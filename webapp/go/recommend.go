@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/isucon/isucon10-qualify/isuumo/recommend"
+)
+
+// おすすめ物件の並べ方
+// popularity は人気順 (既定)、score は扉の余裕・人気度・特徴の相性を合わせたスコア順
+const (
+	RecommendStrategyPopularity = "popularity"
+	RecommendStrategyScore      = "score"
+)
+
+// RecommendCandidateLimit スコアを計算する候補の物件数の上限
+// 人気順に取得するので、上限を超えた分は人気度が低くスコアも伸びにくい物件になる
+const RecommendCandidateLimit = 1000
+
+// doorFitCondition 椅子の2辺が扉の幅・高さに収まる組み合わせのいずれかを満たす条件
+// パラメータは (w, h, w, d, h, w, h, d, d, w, d, h) の順
+const doorFitCondition = `((door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?) OR (door_width >= ? AND door_height >= ?))`
+
+type RecommendedEstate struct {
+	Estate
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+type RecommendedEstateListResponse struct {
+	Estates []RecommendedEstate `json:"estates"`
+}
+
+// searchRankedEstateWithChair 椅子が通る物件を recommend.Rank のスコア順に返す
+func searchRankedEstateWithChair(c echo.Context, chair Chair) error {
+	w := chair.Width
+	h := chair.Height
+	d := chair.Depth
+	var estates []Estate
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE ` + doorFitCondition + ` ORDER BY popularity DESC, id ASC LIMIT ?`
+	err := db.Select(&estates, query, w, h, w, d, h, w, h, d, d, w, d, h, RecommendCandidateLimit)
+	if err != nil {
+		c.Logger().Errorf("Database execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	candidates := make([]recommend.Estate, 0, len(estates))
+	byID := make(map[int64]Estate, len(estates))
+	for _, e := range estates {
+		candidates = append(candidates, recommend.Estate{
+			ID:         e.ID,
+			DoorWidth:  e.DoorWidth,
+			DoorHeight: e.DoorHeight,
+			Popularity: e.Popularity,
+			Features:   splitFeatures(e.Features),
+		})
+		byID[e.ID] = e
+	}
+
+	target := recommend.Chair{Width: w, Height: h, Depth: d, Features: splitFeatures(chair.Features)}
+	res := RecommendedEstateListResponse{Estates: []RecommendedEstate{}}
+	for _, r := range recommend.Rank(target, candidates, Limit) {
+		res.Estates = append(res.Estates, RecommendedEstate{
+			Estate: byID[r.Estate.ID],
+			Score:  r.Score,
+			Reason: r.Reason,
+		})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// chairFitCondition 椅子の2辺が扉の幅・高さに収まる組み合わせのいずれかを満たす条件
+// doorFitCondition を椅子側から見たもので、パラメータは (dw, dh) を6回繰り返す
+const chairFitCondition = `((width <= ? AND height <= ?) OR (width <= ? AND depth <= ?) OR (height <= ? AND width <= ?) OR (height <= ? AND depth <= ?) OR (depth <= ? AND width <= ?) OR (depth <= ? AND height <= ?))`
+
+// searchRecommendedChairWithEstate 物件の扉を通る在庫のある椅子を人気順に返す
+func searchRecommendedChairWithEstate(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Logger().Infof("Invalid format searchRecommendedChairWithEstate id : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	estate := Estate{}
+	query := `SELECT ` + estateColumns + ` FROM estate WHERE id = ?`
+	err = db.Get(&estate, query, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Logger().Infof("Requested estate id \"%v\" not found", id)
+			return c.NoContent(http.StatusBadRequest)
+		}
+		c.Logger().Errorf("Database execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	dw := estate.DoorWidth
+	dh := estate.DoorHeight
+	chairs := []Chair{}
+	query = `SELECT ` + chairColumns + ` FROM chair WHERE stock > 0 AND ` + chairFitCondition + ` ORDER BY popularity DESC, id ASC LIMIT ?`
+	err = db.Select(&chairs, query, dw, dh, dw, dh, dw, dh, dw, dh, dw, dh, dw, dh, Limit)
+	if err != nil {
+		c.Logger().Errorf("Database execution error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, ChairListResponse{Chairs: chairs})
+}
@@ -0,0 +1,158 @@
+package recommend
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// 各要素の重み
+// 合計が1なのでスコアは 0 以上 1 以下になる
+const (
+	FitWeight        = 0.4
+	PopularityWeight = 0.4
+	FeatureWeight    = 0.2
+)
+
+// Chair スコア計算に使う椅子の属性
+type Chair struct {
+	Width    int64
+	Height   int64
+	Depth    int64
+	Features []string
+}
+
+// Estate スコア計算に使う物件の属性
+type Estate struct {
+	ID         int64
+	DoorWidth  int64
+	DoorHeight int64
+	Popularity int64
+	Features   []string
+}
+
+// Result 物件のスコアとその内訳
+type Result struct {
+	Estate     Estate
+	Score      float64
+	Fit        float64
+	Popularity float64
+	Matched    []string
+	Reason     string
+}
+
+// featureAffinity 椅子の特徴と相性のよい物件の特徴
+// 椅子と物件の特徴は別の語彙なので、文字列の一致ではなくこの対応表で重なりを数える
+// 物件には色の属性がないため、椅子の色はスコアに使わない
+var featureAffinity = map[string][]string{
+	"キャスター付き":    {"フローリング"},
+	"モーター付き":     {"オール電化"},
+	"リクライニング可能":  {"ワンルーム", "ロフト"},
+	"ベッド一体型":     {"ワンルーム", "ロフト"},
+	"ディスプレイ配置可能": {"インターネット無料", "インターネット接続可", "テレビ・通信", "ケーブルテレビ"},
+	"オフィス用":      {"インターネット無料", "インターネット接続可"},
+	"料理店用":       {"システムキッチン", "IHコンロ"},
+	"キャンプ用":      {"専用庭", "ルーフバルコニー付", "トランクルーム"},
+	"デザイナーズ":     {"デザイナーズ物件"},
+	"和風":         {"床下収納"},
+	"木製":         {"DIY可", "カスタマイズ可"},
+	"自宅用":        {"ペット相談可", "即入居可"},
+	"クッション性抜群":   {"防音室", "楽器相談可"},
+}
+
+// Fit 椅子が扉を通るときの余裕を 0 以上 1 以下で返す
+// 椅子の2辺と扉の幅・高さの組み合わせのうち、余裕の割合が小さい方の辺が最も大きくなるものを採用する
+// 通らない場合は false を返す
+func Fit(chair Chair, doorWidth, doorHeight int64) (float64, bool) {
+	dims := [3]int64{chair.Width, chair.Height, chair.Depth}
+	best, ok := 0.0, false
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if i == j || dims[i] > doorWidth || dims[j] > doorHeight {
+				continue
+			}
+			margin := math.Min(slack(dims[i], doorWidth), slack(dims[j], doorHeight))
+			if !ok || margin > best {
+				best, ok = margin, true
+			}
+		}
+	}
+	return best, ok
+}
+
+func slack(size, door int64) float64 {
+	if door <= 0 {
+		return 0
+	}
+	return float64(door-size) / float64(door)
+}
+
+// MatchedFeatures 椅子の特徴と相性のよい物件の特徴を物件の特徴の順で返す
+func MatchedFeatures(chair Chair, estate Estate) []string {
+	wanted := map[string]bool{}
+	for _, f := range chair.Features {
+		wanted[f] = true
+		for _, a := range featureAffinity[f] {
+			wanted[a] = true
+		}
+	}
+	matched := []string{}
+	for _, f := range estate.Features {
+		if wanted[f] {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+// Rank 椅子が通る物件をスコアの降順、同点の場合は ID の昇順に並べて最大 limit 件返す
+// 人気度は候補内の最大値で正規化するため、同じ物件でも候補によってスコアは変わる
+func Rank(chair Chair, estates []Estate, limit int) []Result {
+	var maxPopularity int64
+	for _, e := range estates {
+		if e.Popularity > maxPopularity {
+			maxPopularity = e.Popularity
+		}
+	}
+
+	results := make([]Result, 0, len(estates))
+	for _, e := range estates {
+		fit, ok := Fit(chair, e.DoorWidth, e.DoorHeight)
+		if !ok {
+			continue
+		}
+		r := Result{Estate: e, Fit: fit, Matched: MatchedFeatures(chair, e)}
+		if maxPopularity > 0 {
+			r.Popularity = float64(e.Popularity) / float64(maxPopularity)
+		}
+		feature := 0.0
+		if len(chair.Features) > 0 {
+			feature = math.Min(1, float64(len(r.Matched))/float64(len(chair.Features)))
+		}
+		r.Score = FitWeight*r.Fit + PopularityWeight*r.Popularity + FeatureWeight*feature
+		r.Reason = reason(r)
+		results = append(results, r)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Estate.ID < results[j].Estate.ID
+	})
+	if limit >= 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// reason スコアの内訳を利用者向けの文にする
+func reason(r Result) string {
+	parts := []string{fmt.Sprintf("扉の余裕 %d%%", int(math.Round(r.Fit*100)))}
+	parts = append(parts, fmt.Sprintf("人気度 %d%%", int(math.Round(r.Popularity*100))))
+	if len(r.Matched) > 0 {
+		parts = append(parts, "相性のよい特徴: "+strings.Join(r.Matched, "・"))
+	}
+	return strings.Join(parts, "、")
+}
@@ -0,0 +1,80 @@
+package recommend
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	chair := Chair{Width: 50, Height: 100, Depth: 60}
+
+	tests := []struct {
+		name       string
+		doorWidth  int64
+		doorHeight int64
+		want       float64
+		ok         bool
+	}{
+		{name: "exact", doorWidth: 50, doorHeight: 60, want: 0, ok: true},
+		{name: "rotated", doorWidth: 100, doorHeight: 50, want: 0, ok: true},
+		{name: "roomy", doorWidth: 100, doorHeight: 200, want: 0.5, ok: true},
+		{name: "best orientation", doorWidth: 60, doorHeight: 120, want: 1.0 / 6, ok: true},
+		{name: "too narrow", doorWidth: 49, doorHeight: 200, ok: false},
+		{name: "too low", doorWidth: 200, doorHeight: 49, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Fit(chair, tt.doorWidth, tt.doorHeight)
+			if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Fit(%v, %v) = %v, %v, want %v, %v", tt.doorWidth, tt.doorHeight, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchedFeatures(t *testing.T) {
+	chair := Chair{Features: []string{"キャスター付き", "デザイナーズ", "法事用"}}
+	estate := Estate{Features: []string{"デザイナーズ物件", "最上階", "フローリング"}}
+
+	want := []string{"デザイナーズ物件", "フローリング"}
+	if got := MatchedFeatures(chair, estate); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchedFeatures() = %v, want %v", got, want)
+	}
+}
+
+func TestRank(t *testing.T) {
+	chair := Chair{Width: 50, Height: 100, Depth: 60, Features: []string{"キャスター付き"}}
+	estates := []Estate{
+		// 人気だが扉がぎりぎり
+		{ID: 1, DoorWidth: 50, DoorHeight: 60, Popularity: 1000},
+		// 扉に余裕があり、特徴の相性もよい
+		{ID: 2, DoorWidth: 100, DoorHeight: 200, Popularity: 800, Features: []string{"フローリング"}},
+		// 椅子が通らない
+		{ID: 3, DoorWidth: 40, DoorHeight: 40, Popularity: 2000},
+		// 2 と同じスコア
+		{ID: 4, DoorWidth: 100, DoorHeight: 200, Popularity: 800, Features: []string{"フローリング"}},
+	}
+
+	results := Rank(chair, estates, 10)
+	ids := []int64{}
+	for _, r := range results {
+		ids = append(ids, r.Estate.ID)
+	}
+	if want := []int64{2, 4, 1}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Rank() ids = %v, want %v", ids, want)
+	}
+
+	// 人気度は候補内の最大値 (通らない物件を含む) で正規化する
+	want := FitWeight*0.5 + PopularityWeight*0.4 + FeatureWeight*1
+	if math.Abs(results[0].Score-want) > 1e-9 {
+		t.Errorf("score = %v, want %v", results[0].Score, want)
+	}
+	if results[0].Reason != "扉の余裕 50%、人気度 40%、相性のよい特徴: フローリング" {
+		t.Errorf("unexpected reason: %v", results[0].Reason)
+	}
+
+	if got := Rank(chair, estates, 1); len(got) != 1 || got[0].Estate.ID != 2 {
+		t.Errorf("Rank() with limit = %+v", got)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo"
+)
+
+// DefaultReservationSeconds, MaxReservationSeconds 在庫を確保しておく秒数のデフォルト値と上限
+const DefaultReservationSeconds = 300
+const MaxReservationSeconds = 1800
+
+// ReservationSweepInterval 期限切れの予約を在庫に戻す間隔
+const ReservationSweepInterval = 5 * time.Second
+
+// reservationSweepBatchSize 1回のスイープで在庫に戻す予約の上限
+const reservationSweepBatchSize = 1000
+
+// ChairReservation 購入のために確保したイスの在庫1つ分
+// 予約中の在庫は chair.stock から差し引かれている
+type ChairReservation struct {
+	Token     string    `db:"token" json:"token"`
+	ChairID   int64     `db:"chair_id" json:"chairId"`
+	ExpiresAt time.Time `db:"expires_at" json:"expiresAt"`
+}
+
+func newReservationToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// reserveChair イスの在庫を1つ確保し、購入時に使うトークンを返す
+// 確保する秒数は seconds クエリパラメータで指定する
+func reserveChair(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("post reserve chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	seconds := DefaultReservationSeconds
+	if s := c.QueryParam("seconds"); s != "" {
+		seconds, err = strconv.Atoi(s)
+		if err != nil || seconds <= 0 || seconds > MaxReservationSeconds {
+			c.Echo().Logger.Infof("post reserve chair failed : invalid seconds \"%v\"", s)
+			return c.NoContent(http.StatusBadRequest)
+		}
+	}
+
+	token, err := newReservationToken()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to generate reservation token : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	var chair Chair
+	err = tx.QueryRowx("SELECT "+chairColumns+" FROM chair WHERE id = ? AND stock > 0 FOR UPDATE", id).StructScan(&chair)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.Echo().Logger.Infof("reserveChair chair id \"%v\" not found", id)
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	_, err = tx.Exec("UPDATE chair SET stock = stock - 1 WHERE id = ?", id)
+	if err != nil {
+		c.Echo().Logger.Errorf("chair stock update failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	reservation := ChairReservation{
+		Token:     token,
+		ChairID:   int64(id),
+		ExpiresAt: time.Now().Add(time.Duration(seconds) * time.Second).Truncate(time.Microsecond),
+	}
+	_, err = tx.Exec("INSERT INTO chair_reservation(token, chair_id, expires_at) VALUES(?, ?, ?)", reservation.Token, reservation.ChairID, reservation.ExpiresAt)
+	if err != nil {
+		c.Echo().Logger.Errorf("chair reservation insert failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// 在庫は一覧のレスポンスに含まれないため、売り切れた場合のみ影響する
+	if chair.Stock <= 1 {
+		chair.Stock = 0
+		invalidateLowPricedChairIfAffected([]Chair{chair})
+	}
+
+	return c.JSON(http.StatusCreated, reservation)
+}
+
+// consumeReservation 予約を購入に使い、削除する
+// 在庫は予約時に差し引いているため、ここでは減らさない
+func consumeReservation(tx *sqlx.Tx, token string, chairID int64) (int, error) {
+	// スイーパーや在庫の更新と同じく、イスの行ロックを先に取る
+	var id int64
+	err := tx.Get(&id, "SELECT id FROM chair WHERE id = ? FOR UPDATE", chairID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusInternalServerError, err
+	}
+
+	var reservation ChairReservation
+	err = tx.Get(&reservation, "SELECT token, chair_id, expires_at FROM chair_reservation WHERE token = ? FOR UPDATE", token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return http.StatusNotFound, nil
+		}
+		return http.StatusInternalServerError, err
+	}
+	if reservation.ChairID != chairID {
+		return http.StatusBadRequest, nil
+	}
+	// 期限切れの予約はスイーパーが在庫に戻すので、ここでは消費させない
+	if !reservation.ExpiresAt.After(time.Now()) {
+		return http.StatusGone, nil
+	}
+
+	_, err = tx.Exec("DELETE FROM chair_reservation WHERE token = ?", token)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// runReservationSweeper ctx がキャンセルされるまで interval ごとに期限切れの予約を在庫に戻す
+func runReservationSweeper(ctx context.Context, interval time.Duration, logger echo.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := sweepExpiredReservations(ctx, time.Now())
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Errorf("failed to sweep expired reservations : %v", err)
+				continue
+			}
+			if n > 0 {
+				logger.Infof("released %v expired reservations", n)
+			}
+		}
+	}
+}
+
+// reservationSweepRetries デッドロックで失敗したスイープをやり直す回数の上限
+const reservationSweepRetries = 3
+
+// sweepExpiredReservations now の時点で期限切れの予約を削除して在庫に戻し、戻した数を返す
+// デッドロックで失敗した場合はやり直す
+func sweepExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	for i := 0; ; i++ {
+		n, err := sweepExpiredReservationsOnce(ctx, now)
+		if err != nil && isDeadlock(err) && i < reservationSweepRetries {
+			continue
+		}
+		return n, err
+	}
+}
+
+func sweepExpiredReservationsOnce(ctx context.Context, now time.Time) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var candidates []ChairReservation
+	err = tx.SelectContext(ctx, &candidates, "SELECT token, chair_id, expires_at FROM chair_reservation WHERE expires_at <= ? ORDER BY expires_at LIMIT ?", now, reservationSweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+
+	// 予約や購入と同じく chair, chair_reservation の順にロックを取る
+	// イス同士は購入処理とのデッドロックを避けるため、id の昇順でロックする
+	chairIDs := []int64{}
+	tokens := make([]string, 0, len(candidates))
+	seen := map[int64]bool{}
+	for _, r := range candidates {
+		if !seen[r.ChairID] {
+			seen[r.ChairID] = true
+			chairIDs = append(chairIDs, r.ChairID)
+		}
+		tokens = append(tokens, r.Token)
+	}
+	sort.Slice(chairIDs, func(i, j int) bool {
+		return chairIDs[i] < chairIDs[j]
+	})
+	query, params, err := sqlx.In("SELECT id FROM chair WHERE id IN (?) ORDER BY id FOR UPDATE", chairIDs)
+	if err != nil {
+		return 0, err
+	}
+	var locked []int64
+	if err := tx.SelectContext(ctx, &locked, query, params...); err != nil {
+		return 0, err
+	}
+
+	// ロックを取るまでの間に購入やイスの削除で消えた予約は戻さない
+	var expired []ChairReservation
+	query, params, err = sqlx.In("SELECT token, chair_id, expires_at FROM chair_reservation WHERE token IN (?) FOR UPDATE", tokens)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.SelectContext(ctx, &expired, query, params...); err != nil {
+		return 0, err
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+
+	released := map[int64]int64{}
+	tokens = tokens[:0]
+	for _, r := range expired {
+		released[r.ChairID]++
+		tokens = append(tokens, r.Token)
+	}
+
+	query, params, err = sqlx.In("DELETE FROM chair_reservation WHERE token IN (?)", tokens)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return 0, err
+	}
+
+	for _, id := range chairIDs {
+		if released[id] == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE chair SET stock = stock + ? WHERE id = ?", released[id], id); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// 売り切れていたイスが一覧に戻りうるため、キャッシュを捨てる
+	lowPricedChairCache.Invalidate()
+	return len(expired), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSweepExpiredReservations(t *testing.T) {
+	setupTestDB(t)
+
+	insertTestChair(t, 0, "座椅子")
+
+	now := time.Now()
+	prefix := fmt.Sprintf("sweep-test-%d", now.UnixNano())
+	// 期限切れの予約 2 つと、まだ有効な予約 1 つ
+	for i, expiresAt := range []time.Time{now.Add(-time.Minute), now.Add(-time.Second), now.Add(time.Minute)} {
+		_, err := db.Exec("INSERT INTO chair_reservation(token, chair_id, expires_at) VALUES(?, ?, ?)", fmt.Sprintf("%s-%d", prefix, i), testChairID, expiresAt)
+		if err != nil {
+			t.Fatal("failed to insert reservation:", err)
+		}
+	}
+
+	if _, err := sweepExpiredReservations(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+
+	var stock int64
+	if err := db.Get(&stock, "SELECT stock FROM chair WHERE id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if stock != 2 {
+		t.Errorf("unexpected stock. expected: 2, but got: %v", stock)
+	}
+	var remaining int64
+	if err := db.Get(&remaining, "SELECT COUNT(*) FROM chair_reservation WHERE chair_id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 1 {
+		t.Errorf("unexpected number of reservations. expected: 1, but got: %v", remaining)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SQLStatement SQLファイルから切り出した1文と、その開始行番号
+type SQLStatement struct {
+	Query string
+	Line  int
+}
+
+// SQLScriptError どのファイルの何行目の文で失敗したかを保持する
+type SQLScriptError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *SQLScriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *SQLScriptError) Unwrap() error {
+	return e.Err
+}
+
+// splitSQLStatements セミコロン区切りで複数の文を含むSQLを1文ずつに分割する
+// 文字列リテラル・識別子のクォートとコメント中のセミコロンは区切りとして扱わない
+func splitSQLStatements(r io.Reader) ([]SQLStatement, error) {
+	br := bufio.NewReader(r)
+
+	statements := []SQLStatement{}
+	var buf strings.Builder
+	line, startLine := 1, 0
+
+	// quote は現在のクォート文字 (', ", `) で、クォートの外なら 0
+	var quote rune
+	inLineComment, inBlockComment := false, false
+	escaped := false
+
+	flush := func() {
+		q := strings.TrimSpace(buf.String())
+		if q != "" {
+			statements = append(statements, SQLStatement{Query: q, Line: startLine})
+		}
+		buf.Reset()
+		startLine = 0
+	}
+
+	for {
+		ch, _, err := br.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case inLineComment:
+			if ch == '\n' {
+				inLineComment = false
+			}
+		case inBlockComment:
+			if ch == '*' && peekIs(br, "/") {
+				br.ReadRune()
+				inBlockComment = false
+			}
+		case quote != 0:
+			buf.WriteRune(ch)
+			if escaped {
+				escaped = false
+			} else if ch == '\\' && quote != '`' {
+				escaped = true
+			} else if ch == quote {
+				quote = 0
+			}
+		default:
+			switch ch {
+			case ';':
+				flush()
+			case '#':
+				inLineComment = true
+			case '-':
+				// MySQL では "-- " のように空白が続く場合のみコメントになる
+				if isDashComment(br) {
+					br.ReadRune()
+					inLineComment = true
+					continue
+				}
+				fallthrough
+			case '/':
+				if ch == '/' && peekIs(br, "*") {
+					br.ReadRune()
+					inBlockComment = true
+					continue
+				}
+				fallthrough
+			default:
+				if ch == '\'' || ch == '"' || ch == '`' {
+					quote = ch
+				}
+				if startLine == 0 && !isSpace(ch) {
+					startLine = line
+				}
+				buf.WriteRune(ch)
+			}
+		}
+
+		if ch == '\n' {
+			line++
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("line %d: unterminated quoted string", startLine)
+	}
+	flush()
+
+	return statements, nil
+}
+
+func peekIs(br *bufio.Reader, s string) bool {
+	b, err := br.Peek(len(s))
+	return err == nil && string(b) == s
+}
+
+// isDashComment 直前に読んだ '-' に続けて "-" と空白 (またはEOF) が来るかを判定する
+func isDashComment(br *bufio.Reader) bool {
+	b, _ := br.Peek(2)
+	if len(b) == 0 || b[0] != '-' {
+		return false
+	}
+	return len(b) == 1 || isSpace(rune(b[1]))
+}
+
+func isSpace(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+// execSQLFile SQLファイルを1文ずつ conn 上で実行し、実行した文の数を返す
+func execSQLFile(ctx context.Context, conn *sql.Conn, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	statements, err := splitSQLStatements(f)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", name, err)
+	}
+
+	for i, s := range statements {
+		if _, err := conn.ExecContext(ctx, s.Query); err != nil {
+			return i, &SQLScriptError{File: name, Line: s.Line, Err: err}
+		}
+	}
+
+	return len(statements), nil
+}
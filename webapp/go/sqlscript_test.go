@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []SQLStatement
+	}{
+		{
+			name: "statements and line numbers",
+			sql:  "SELECT 1;\n\nSELECT\n  2;\n",
+			want: []SQLStatement{{Query: "SELECT 1", Line: 1}, {Query: "SELECT\n  2", Line: 3}},
+		},
+		{
+			name: "missing trailing semicolon",
+			sql:  "SELECT 1;\nSELECT 2\n",
+			want: []SQLStatement{{Query: "SELECT 1", Line: 1}, {Query: "SELECT 2", Line: 2}},
+		},
+		{
+			name: "semicolon in quotes",
+			sql:  "INSERT INTO t VALUES('a;b', \"c;d\");\nSELECT `e;f` FROM t;",
+			want: []SQLStatement{{Query: "INSERT INTO t VALUES('a;b', \"c;d\")", Line: 1}, {Query: "SELECT `e;f` FROM t", Line: 2}},
+		},
+		{
+			name: "escaped quotes",
+			sql:  "SELECT 'it\\'s; ok';\nSELECT 'it''s; ok';\nSELECT \"say \\\"hi;\\\"\";",
+			want: []SQLStatement{{Query: "SELECT 'it\\'s; ok'", Line: 1}, {Query: "SELECT 'it''s; ok'", Line: 2}, {Query: "SELECT \"say \\\"hi;\\\"\"", Line: 3}},
+		},
+		{
+			name: "backslash in backquote",
+			sql:  "SELECT `a\\`; SELECT 2;",
+			want: []SQLStatement{{Query: "SELECT `a\\`", Line: 1}, {Query: "SELECT 2", Line: 1}},
+		},
+		{
+			name: "semicolon in line comments",
+			sql:  "-- first; comment\nSELECT 1; # second; comment\nSELECT 2;",
+			want: []SQLStatement{{Query: "SELECT 1", Line: 2}, {Query: "SELECT 2", Line: 3}},
+		},
+		{
+			name: "semicolon in block comment",
+			sql:  "/* header;\n   comment; */\nSELECT /* ; */ 1;\nSELECT 2;",
+			want: []SQLStatement{{Query: "SELECT  1", Line: 3}, {Query: "SELECT 2", Line: 4}},
+		},
+		{
+			name: "double dash without space",
+			sql:  "SELECT 1--1;\nSELECT 2 -- comment\n;",
+			want: []SQLStatement{{Query: "SELECT 1--1", Line: 1}, {Query: "SELECT 2", Line: 2}},
+		},
+		{
+			name: "empty statements",
+			sql:  ";;\n-- only comment\n",
+			want: []SQLStatement{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitSQLStatements(strings.NewReader(tt.sql))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitSQLStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitSQLStatements_Unterminated(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want string
+	}{
+		{name: "single quote", sql: "SELECT 1;\n\nSELECT 'abc;\nSELECT 2;", want: "line 3: unterminated quoted string"},
+		{name: "escaped closing quote", sql: "SELECT 'abc\\';", want: "line 1: unterminated quoted string"},
+		{name: "backquote", sql: "SELECT 1;\nSELECT `a;", want: "line 2: unterminated quoted string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := splitSQLStatements(strings.NewReader(tt.sql))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("splitSQLStatements() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecSQLFile_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlscript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	path := filepath.Join(dir, "broken.sql")
+	if err := ioutil.WriteFile(path, []byte("SELECT 1;\n-- comment\nSELECT 'abc;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分割に失敗したファイルは DB に送らない
+	n, err := execSQLFile(context.Background(), nil, path)
+	if n != 0 || err == nil || err.Error() != "broken.sql: line 3: unterminated quoted string" {
+		t.Errorf("execSQLFile() = %v, %v", n, err)
+	}
+
+	if _, err := execSQLFile(context.Background(), nil, filepath.Join(dir, "missing.sql")); !os.IsNotExist(err) {
+		t.Errorf("execSQLFile() error = %v, want not exist", err)
+	}
+}
+
+func TestSQLScriptError(t *testing.T) {
+	cause := errors.New("Error 1064: You have an error in your SQL syntax")
+	err := error(&SQLScriptError{File: "0_Schema.sql", Line: 12, Err: cause})
+
+	if got, want := err.Error(), "0_Schema.sql:12: Error 1064: You have an error in your SQL syntax"; got != want {
+		t.Errorf("Error() = %v, want %v", got, want)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("SQLScriptError should unwrap to the cause")
+	}
+}
+
+// 初期化で流すスキーマがエラーなく分割できること
+func TestSplitSQLStatements_Schema(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "mysql", "db", "go", "0_Schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	statements, err := splitSQLStatements(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statements {
+		if !strings.HasPrefix(s.Query, "DROP") && !strings.HasPrefix(s.Query, "CREATE") {
+			t.Errorf("line %d: unexpected statement: %q", s.Line, s.Query)
+		}
+	}
+}
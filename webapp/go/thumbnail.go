@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+
+	"github.com/isucon/isucon10-qualify/isuumo/thumbnail"
+)
+
+// ThumbnailURLPrefix アップロードされた画像を配信するパス
+const ThumbnailURLPrefix = "/api/thumbnails/"
+
+// thumbnailStore アップロードされた画像の保存先
+var thumbnailStore *thumbnail.Store
+
+type ThumbnailResponse struct {
+	Thumbnail string `json:"thumbnail"`
+	Original  string `json:"original"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+func postChairThumbnail(c echo.Context) error {
+	return postThumbnail(c, "chair")
+}
+
+func postEstateThumbnail(c echo.Context) error {
+	return postThumbnail(c, "estate")
+}
+
+// postThumbnail multipart/form-data の thumbnail で送られた画像を保存し、縮小版を table の thumbnail に設定する
+func postThumbnail(c echo.Context, table string) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("post %s thumbnail failed : %v", table, err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	header, err := c.FormFile("thumbnail")
+	if err != nil {
+		c.Echo().Logger.Infof("post %s thumbnail failed : %v", table, err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if header.Size > thumbnail.MaxUploadSize {
+		return c.NoContent(http.StatusRequestEntityTooLarge)
+	}
+	switch header.Header.Get(echo.HeaderContentType) {
+	case thumbnail.ContentTypePNG, thumbnail.ContentTypeJPEG:
+	default:
+		c.Echo().Logger.Infof("post %s thumbnail failed : unsupported content type %v", table, header.Header.Get(echo.HeaderContentType))
+		return c.NoContent(http.StatusUnsupportedMediaType)
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		c.Logger().Errorf("failed to open form file: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer f.Close()
+
+	img, err := thumbnailStore.Save(f)
+	switch err {
+	case nil:
+	case thumbnail.ErrTooLarge:
+		return c.NoContent(http.StatusRequestEntityTooLarge)
+	case thumbnail.ErrUnsupportedType:
+		c.Echo().Logger.Infof("post %s thumbnail failed : %v", table, err)
+		return c.NoContent(http.StatusUnsupportedMediaType)
+	case thumbnail.ErrInvalidDimensions:
+		c.Echo().Logger.Infof("post %s thumbnail failed : %v", table, err)
+		return c.NoContent(http.StatusBadRequest)
+	default:
+		c.Logger().Errorf("failed to save thumbnail: %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if img.ContentType != header.Header.Get(echo.HeaderContentType) {
+		c.Echo().Logger.Infof("post %s thumbnail failed : content type %v does not match %v", table, header.Header.Get(echo.HeaderContentType), img.ContentType)
+		return c.NoContent(http.StatusUnsupportedMediaType)
+	}
+
+	res := ThumbnailResponse{
+		Thumbnail: ThumbnailURLPrefix + img.VariantName(),
+		Original:  ThumbnailURLPrefix + img.Name(),
+		Width:     img.Width,
+		Height:    img.Height,
+	}
+	result, err := db.Exec("UPDATE "+table+" SET thumbnail = ?, version = version + 1 WHERE id = ?", res.Thumbnail, id)
+	if err != nil {
+		c.Logger().Errorf("failed to update %s thumbnail: %v", table, err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return c.NoContent(http.StatusNotFound)
+	}
+
+	// 一覧のレスポンスに thumbnail が含まれるため捨てる
+	if table == "chair" {
+		lowPricedChairCache.Invalidate()
+	} else {
+		lowPricedEstateCache.Invalidate()
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,258 @@
+package thumbnail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// MaxUploadSize アップロードできる画像のバイト数の上限
+const MaxUploadSize = 5 << 20
+
+// MaxDimension, MinDimension アップロードできる画像の幅・高さの上限と下限
+const MaxDimension = 4096
+const MinDimension = 16
+
+// VariantSize 縮小版の長辺のピクセル数
+const VariantSize = 320
+
+// CacheControl 画像のレスポンスの Cache-Control
+// ファイル名が内容のハッシュなので、同じ URL の内容は変わらない
+const CacheControl = "public, max-age=31536000, immutable"
+
+var (
+	ErrTooLarge          = errors.New("image is too large")
+	ErrUnsupportedType   = errors.New("unsupported content type")
+	ErrInvalidDimensions = errors.New("invalid image dimensions")
+)
+
+const (
+	ContentTypePNG  = "image/png"
+	ContentTypeJPEG = "image/jpeg"
+)
+
+var extensions = map[string]string{
+	ContentTypePNG:  ".png",
+	ContentTypeJPEG: ".jpg",
+}
+
+var contentTypes = map[string]string{
+	".png": ContentTypePNG,
+	".jpg": ContentTypeJPEG,
+}
+
+const variantSuffix = "_s"
+
+var namePattern = regexp.MustCompile(`^([0-9a-f]{64})(` + variantSuffix + `)?(\.png|\.jpg)$`)
+
+// Image 保存した画像
+type Image struct {
+	Hash        string
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Name 元の画像のファイル名
+func (i Image) Name() string {
+	return i.Hash + extensions[i.ContentType]
+}
+
+// VariantName 縮小版のファイル名
+func (i Image) VariantName() string {
+	return i.Hash + variantSuffix + extensions[i.ContentType]
+}
+
+// Store 画像を内容の SHA-256 をファイル名としてディレクトリに保存する
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Save PNG か JPEG の画像を検証して保存し、縮小版を作る
+// 同じ内容の画像が既にあれば保存し直さない
+func (s *Store) Save(r io.Reader) (Image, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return Image{}, err
+	}
+	if len(data) > MaxUploadSize {
+		return Image{}, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(data)
+	if _, ok := extensions[contentType]; !ok {
+		return Image{}, ErrUnsupportedType
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	// 拡張子や Content-Type ヘッダではなく、内容から判定した形式が一致することを確かめる
+	if err != nil || "image/"+format != contentType {
+		return Image{}, ErrUnsupportedType
+	}
+	if config.Width < MinDimension || config.Height < MinDimension || config.Width > MaxDimension || config.Height > MaxDimension {
+		return Image{}, ErrInvalidDimensions
+	}
+
+	sum := sha256.Sum256(data)
+	img := Image{
+		Hash:        hex.EncodeToString(sum[:]),
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+
+	if s.exists(img.Name()) && s.exists(img.VariantName()) {
+		return img, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, ErrUnsupportedType
+	}
+	var variant bytes.Buffer
+	resized := Resize(src, VariantSize)
+	if contentType == ContentTypePNG {
+		err = png.Encode(&variant, resized)
+	} else {
+		err = jpeg.Encode(&variant, resized, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return Image{}, err
+	}
+
+	if err := s.write(img.Name(), data); err != nil {
+		return Image{}, err
+	}
+	if err := s.write(img.VariantName(), variant.Bytes()); err != nil {
+		return Image{}, err
+	}
+	return img, nil
+}
+
+func (s *Store) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(s.dir, name))
+	return err == nil
+}
+
+// write 書き込み途中のファイルを配信しないよう、一時ファイルに書いてからリネームする
+func (s *Store) write(name string, data []byte) error {
+	f, err := ioutil.TempFile(s.dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(s.dir, name))
+}
+
+// Handler :name パラメータで指定した画像を ETag と Cache-Control を付けて返す
+func (s *Store) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("name")
+		m := namePattern.FindStringSubmatch(name)
+		if m == nil {
+			return c.NoContent(http.StatusNotFound)
+		}
+
+		f, err := os.Open(filepath.Join(s.dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return c.NoContent(http.StatusNotFound)
+			}
+			c.Logger().Errorf("failed to open thumbnail : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		defer f.Close()
+
+		h := c.Response().Header()
+		h.Set(echo.HeaderContentType, contentTypes[m[3]])
+		h.Set("ETag", `"`+m[1]+m[2]+`"`)
+		h.Set("Cache-Control", CacheControl)
+		// If-None-Match の判定は ServeContent が ETag ヘッダを見て行う
+		http.ServeContent(c.Response(), c.Request(), name, time.Time{}, f)
+		return nil
+	}
+}
+
+// Resize 縦横比を保ったまま長辺が size 以下になるように縮小する
+// 縮小後の1ピクセルに対応する元画像の範囲の平均を取る
+func Resize(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, max(1, sh*size/sw)
+		} else {
+			dw, dh = max(1, sw*size/sh), size
+		}
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if dw == sw && dh == sh {
+		return rgba
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				off := rgba.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					for i := 0; i < 4; i++ {
+						sum[i] += int(rgba.Pix[off+i])
+					}
+					off += 4
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			off := dst.PixOffset(x, y)
+			for i := 0; i < 4; i++ {
+				dst.Pix[off+i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
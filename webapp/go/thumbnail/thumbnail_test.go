@@ -0,0 +1,205 @@
+package thumbnail
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+// originDir ベンチマーカーの画像の元データ
+const originDir = "../../../initial-data/origin"
+
+func loadOrigins(t *testing.T) [][]byte {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(originDir, "*", "*.png"))
+	if len(paths) == 0 {
+		t.Skipf("origin images not found in %v", originDir)
+	}
+	images := make([][]byte, 0, len(paths))
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, data)
+	}
+	return images
+}
+
+func newTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "thumbnail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, dir
+}
+
+func decodeFile(t *testing.T, path string) (image.Image, string) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, format, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img, format
+}
+
+func TestStore_SaveOrigins(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	for _, data := range loadOrigins(t) {
+		img, err := s.Save(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to save image: %v", err)
+		}
+		if img.ContentType != ContentTypePNG {
+			t.Errorf("unexpected content type: %v", img.ContentType)
+		}
+
+		saved, err := ioutil.ReadFile(filepath.Join(dir, img.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved, data) {
+			t.Errorf("saved image differs from the original")
+		}
+
+		variant, format := decodeFile(t, filepath.Join(dir, img.VariantName()))
+		if format != "png" {
+			t.Errorf("unexpected variant format: %v", format)
+		}
+		b := variant.Bounds()
+		fits := img.Width <= VariantSize && img.Height <= VariantSize
+		if fits && (b.Dx() != img.Width || b.Dy() != img.Height) ||
+			!fits && (b.Dx() > VariantSize || b.Dy() > VariantSize || (b.Dx() != VariantSize && b.Dy() != VariantSize)) {
+			t.Errorf("unexpected variant size: %vx%v (original %vx%v)", b.Dx(), b.Dy(), img.Width, img.Height)
+		}
+
+		again, err := s.Save(bytes.NewReader(data))
+		if err != nil || again != img {
+			t.Errorf("saving the same image should return the same result. got: %+v, %v", again, err)
+		}
+	}
+}
+
+func TestStore_SaveJPEG(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	src := image.NewRGBA(image.Rect(0, 0, 100, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 100; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := s.Save(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != ContentTypeJPEG || img.Width != 100 || img.Height != 40 {
+		t.Errorf("unexpected image: %+v", img)
+	}
+	if _, format := decodeFile(t, filepath.Join(dir, img.VariantName())); format != "jpeg" {
+		t.Errorf("unexpected variant format: %v", format)
+	}
+}
+
+func TestStore_SaveInvalid(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	var tiny bytes.Buffer
+	jpeg.Encode(&tiny, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+
+	cases := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{"text", []byte("not an image"), ErrUnsupportedType},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), ErrUnsupportedType},
+		{"broken png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), ErrUnsupportedType},
+		{"too small", tiny.Bytes(), ErrInvalidDimensions},
+		{"too large", make([]byte, MaxUploadSize+1), ErrTooLarge},
+	}
+	for _, c := range cases {
+		if _, err := s.Save(bytes.NewReader(c.data)); err != c.err {
+			t.Errorf("%v: expected %v, but got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestStore_Handler(t *testing.T) {
+	s, _ := newTestStore(t)
+	data := loadOrigins(t)[0]
+	img, err := s.Save(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.GET("/api/thumbnails/:name", s.Handler())
+	get := func(name, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/thumbnails/"+name, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(img.Name(), "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %v", rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data) {
+		t.Error("unexpected body")
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentTypePNG {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != CacheControl {
+		t.Errorf("unexpected cache control: %v", cc)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag is not set")
+	}
+
+	if rec := get(img.Name(), etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for matching ETag, but got %v", rec.Code)
+	}
+	if rec := get(img.VariantName(), etag); rec.Code != http.StatusOK {
+		t.Errorf("variant should have a different ETag, but got %v", rec.Code)
+	}
+	for _, name := range []string{"../thumbnail.go", img.Hash + ".gif", "0123.png"} {
+		if rec := get(name, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%v: expected 404, but got %v", name, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo"
+)
+
+// ChairUpdateRequest PUT /api/chair/:id のリクエスト
+// Stock には予約中の分も含めた在庫数を指定する。Version には更新前に取得したイスの version を指定し、指定がなければ 412 を返す
+type ChairUpdateRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Thumbnail   string `json:"thumbnail"`
+	Price       int64  `json:"price"`
+	Height      int64  `json:"height"`
+	Width       int64  `json:"width"`
+	Depth       int64  `json:"depth"`
+	Color       string `json:"color"`
+	Features    string `json:"features"`
+	Kind        string `json:"kind"`
+	Popularity  int64  `json:"popularity"`
+	Stock       int64  `json:"stock"`
+	Version     int64  `json:"version"`
+}
+
+// EstatePatchRequest PATCH /api/estate/:id のリクエスト
+// 指定されたフィールドだけを更新する。Version には更新前に取得した物件の version を指定し、指定がなければ 412 を返す
+type EstatePatchRequest struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	Thumbnail   *string  `json:"thumbnail"`
+	Address     *string  `json:"address"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	Rent        *int64   `json:"rent"`
+	DoorHeight  *int64   `json:"doorHeight"`
+	DoorWidth   *int64   `json:"doorWidth"`
+	Features    *string  `json:"features"`
+	Popularity  *int64   `json:"popularity"`
+	Version     int64    `json:"version"`
+}
+
+// replaceFeatures 1行分の table_feature を作り直す
+func replaceFeatures(ctx context.Context, tx *sqlx.Tx, table string, featureIDs map[string]int64, id int64, features string) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %[1]s_feature WHERE %[1]s_id = ?", table), id)
+	if err != nil {
+		return err
+	}
+	return insertFeatures(ctx, tx, table, featureRows(featureIDs, id, features))
+}
+
+// notFoundOrConflict version を指定した UPDATE, DELETE で対象の行がなかったときのステータスを返す
+// 行が存在すれば他の更新と競合したので 409、存在しなければ 404
+func notFoundOrConflict(tx *sqlx.Tx, table string, id int64) (int, error) {
+	var exists bool
+	err := tx.Get(&exists, fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = ?)", table), id)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if exists {
+		return http.StatusConflict, nil
+	}
+	return http.StatusNotFound, nil
+}
+
+func putChair(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("put chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	var req ChairUpdateRequest
+	if err := c.Bind(&req); err != nil {
+		c.Echo().Logger.Infof("put chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if req.Version <= 0 {
+		c.Echo().Logger.Info("put chair failed : version not found in request body")
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+
+	chair := Chair{
+		ID:          int64(id),
+		Name:        req.Name,
+		Description: req.Description,
+		Thumbnail:   req.Thumbnail,
+		Price:       req.Price,
+		Height:      req.Height,
+		Width:       req.Width,
+		Depth:       req.Depth,
+		Color:       req.Color,
+		Features:    req.Features,
+		Kind:        req.Kind,
+		Popularity:  req.Popularity,
+		Stock:       req.Stock,
+		Version:     req.Version + 1,
+	}
+	if err := validateChair(chair); err != nil {
+		c.Echo().Logger.Infof("put chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	ctx := c.Request().Context()
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	// 予約と購入もイスの行ロックを取ってから予約を操作するので、ロックしている間は予約数が変わらない
+	var version int64
+	err = tx.Get(&version, "SELECT version FROM chair WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if version != req.Version {
+		c.Echo().Logger.Infof("put chair failed : version %v is not the latest %v", req.Version, version)
+		return c.NoContent(http.StatusConflict)
+	}
+
+	// chair.stock は予約中の分を差し引いた在庫なので、期限切れで在庫に戻る前の予約も含めて差し引く
+	var reserved int64
+	err = tx.Get(&reserved, "SELECT COUNT(*) FROM chair_reservation WHERE chair_id = ?", id)
+	if err != nil {
+		c.Echo().Logger.Errorf("DB Execution Error: on counting chair reservations : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if chair.Stock < reserved {
+		c.Echo().Logger.Infof("put chair failed : stock %v is less than %v reserved", chair.Stock, reserved)
+		return c.NoContent(http.StatusConflict)
+	}
+	chair.Stock -= reserved
+
+	_, err = tx.Exec("UPDATE chair SET name = ?, description = ?, thumbnail = ?, price = ?, height = ?, width = ?, depth = ?, color = ?, features = ?, kind = ?, popularity = ?, stock = ?, price_range_id = ?, height_range_id = ?, width_range_id = ?, depth_range_id = ?, version = version + 1 WHERE id = ?",
+		chair.Name, chair.Description, chair.Thumbnail, chair.Price, chair.Height, chair.Width, chair.Depth, chair.Color, chair.Features, chair.Kind, chair.Popularity, chair.Stock,
+		getRangeID(chairSearchCondition.Price, chair.Price), getRangeID(chairSearchCondition.Height, chair.Height), getRangeID(chairSearchCondition.Width, chair.Width), getRangeID(chairSearchCondition.Depth, chair.Depth),
+		id)
+	if err != nil {
+		c.Echo().Logger.Errorf("chair update failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := replaceFeatures(ctx, tx, "chair", chairFeatureIDs, int64(id), chair.Features); err != nil {
+		c.Echo().Logger.Errorf("failed to replace chair features : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	lowPricedChairCache.Invalidate()
+
+	return c.JSON(http.StatusOK, chair)
+}
+
+func deleteChair(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("delete chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if c.QueryParam("version") == "" {
+		c.Echo().Logger.Info("delete chair failed : version not found in query")
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	version, err := strconv.ParseInt(c.QueryParam("version"), 10, 64)
+	if err != nil {
+		c.Echo().Logger.Infof("delete chair failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM chair WHERE id = ? AND version = ?", id, version)
+	if err != nil {
+		c.Echo().Logger.Errorf("chair delete failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		status, err := notFoundOrConflict(tx, "chair", int64(id))
+		if err != nil {
+			c.Echo().Logger.Errorf("DB Execution Error: on getting a chair by id : %v", err)
+		}
+		return c.NoContent(status)
+	}
+
+	if _, err := tx.Exec("DELETE FROM chair_feature WHERE chair_id = ?", id); err != nil {
+		c.Echo().Logger.Errorf("chair feature delete failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	// 削除したイスの予約では購入できないようにする
+	if _, err := tx.Exec("DELETE FROM chair_reservation WHERE chair_id = ?", id); err != nil {
+		c.Echo().Logger.Errorf("chair reservation delete failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	lowPricedChairCache.Invalidate()
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func patchEstate(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("patch estate failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	var req EstatePatchRequest
+	if err := c.Bind(&req); err != nil {
+		c.Echo().Logger.Infof("patch estate failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if req.Version <= 0 {
+		c.Echo().Logger.Info("patch estate failed : version not found in request body")
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+
+	ctx := c.Request().Context()
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	// コミットするまで他の更新を待たせ、読み込んだ行に変更を重ねる
+	var estate Estate
+	err = tx.Get(&estate, "SELECT "+estateColumns+" FROM estate WHERE id = ? FOR UPDATE", id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.NoContent(http.StatusNotFound)
+		}
+		c.Echo().Logger.Errorf("DB Execution Error: on getting an estate by id : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if estate.Version != req.Version {
+		c.Echo().Logger.Infof("patch estate failed : version %v is not the latest %v", req.Version, estate.Version)
+		return c.NoContent(http.StatusConflict)
+	}
+
+	if req.Name != nil {
+		estate.Name = *req.Name
+	}
+	if req.Description != nil {
+		estate.Description = *req.Description
+	}
+	if req.Thumbnail != nil {
+		estate.Thumbnail = *req.Thumbnail
+	}
+	if req.Address != nil {
+		estate.Address = *req.Address
+	}
+	if req.Latitude != nil {
+		estate.Latitude = *req.Latitude
+	}
+	if req.Longitude != nil {
+		estate.Longitude = *req.Longitude
+	}
+	if req.Rent != nil {
+		estate.Rent = *req.Rent
+	}
+	if req.DoorHeight != nil {
+		estate.DoorHeight = *req.DoorHeight
+	}
+	if req.DoorWidth != nil {
+		estate.DoorWidth = *req.DoorWidth
+	}
+	if req.Features != nil {
+		estate.Features = *req.Features
+	}
+	if req.Popularity != nil {
+		estate.Popularity = *req.Popularity
+	}
+	if err := validateEstate(estate); err != nil {
+		c.Echo().Logger.Infof("patch estate failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	estate.Version++
+
+	_, err = tx.Exec("UPDATE estate SET name = ?, description = ?, thumbnail = ?, address = ?, latitude = ?, longitude = ?, rent = ?, door_height = ?, door_width = ?, features = ?, popularity = ?, rent_range_id = ?, door_height_range_id = ?, door_width_range_id = ?, version = version + 1 WHERE id = ?",
+		estate.Name, estate.Description, estate.Thumbnail, estate.Address, estate.Latitude, estate.Longitude, estate.Rent, estate.DoorHeight, estate.DoorWidth, estate.Features, estate.Popularity,
+		getRangeID(estateSearchCondition.Rent, estate.Rent), getRangeID(estateSearchCondition.DoorHeight, estate.DoorHeight), getRangeID(estateSearchCondition.DoorWidth, estate.DoorWidth),
+		id)
+	if err != nil {
+		c.Echo().Logger.Errorf("estate update failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if req.Features != nil {
+		if err := replaceFeatures(ctx, tx, "estate", estateFeatureIDs, int64(id), estate.Features); err != nil {
+			c.Echo().Logger.Errorf("failed to replace estate features : %v", err)
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	lowPricedEstateCache.Invalidate()
+
+	return c.JSON(http.StatusOK, estate)
+}
+
+func deleteEstate(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Echo().Logger.Infof("delete estate failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if c.QueryParam("version") == "" {
+		c.Echo().Logger.Info("delete estate failed : version not found in query")
+		return c.NoContent(http.StatusPreconditionFailed)
+	}
+	version, err := strconv.ParseInt(c.QueryParam("version"), 10, 64)
+	if err != nil {
+		c.Echo().Logger.Infof("delete estate failed : %v", err)
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		c.Echo().Logger.Errorf("failed to create transaction : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM estate WHERE id = ? AND version = ?", id, version)
+	if err != nil {
+		c.Echo().Logger.Errorf("estate delete failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		status, err := notFoundOrConflict(tx, "estate", int64(id))
+		if err != nil {
+			c.Echo().Logger.Errorf("DB Execution Error: on getting an estate by id : %v", err)
+		}
+		return c.NoContent(status)
+	}
+
+	if _, err := tx.Exec("DELETE FROM estate_feature WHERE estate_id = ?", id); err != nil {
+		c.Echo().Logger.Errorf("estate feature delete failed : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Echo().Logger.Errorf("transaction commit error : %v", err)
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	lowPricedEstateCache.Invalidate()
+
+	return c.NoContent(http.StatusNoContent)
+}
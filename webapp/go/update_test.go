@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// testEstateID 初期データと衝突しないテスト用の物件の ID
+const testEstateID = 2147483000
+
+func newUpdateTestServer() *echo.Echo {
+	e := echo.New()
+	e.PUT("/api/chair/:id", putChair)
+	e.DELETE("/api/chair/:id", deleteChair)
+	e.PATCH("/api/estate/:id", patchEstate)
+	e.DELETE("/api/estate/:id", deleteEstate)
+	return e
+}
+
+func doUpdateRequest(e *echo.Echo, method, target, body string) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// version の指定がないリクエストは DB を読む前に 412 で拒否する
+func TestUpdate_VersionRequired(t *testing.T) {
+	e := newUpdateTestServer()
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{name: "put chair without version", method: http.MethodPut, target: "/api/chair/1", body: `{"name":"test","stock":1}`, want: http.StatusPreconditionFailed},
+		{name: "put chair with zero version", method: http.MethodPut, target: "/api/chair/1", body: `{"name":"test","version":0}`, want: http.StatusPreconditionFailed},
+		{name: "patch estate without version", method: http.MethodPatch, target: "/api/estate/1", body: `{"rent":1000}`, want: http.StatusPreconditionFailed},
+		{name: "delete chair without version", method: http.MethodDelete, target: "/api/chair/1", want: http.StatusPreconditionFailed},
+		{name: "delete estate without version", method: http.MethodDelete, target: "/api/estate/1", want: http.StatusPreconditionFailed},
+		{name: "delete chair with invalid version", method: http.MethodDelete, target: "/api/chair/1?version=abc", want: http.StatusBadRequest},
+		{name: "delete estate with invalid version", method: http.MethodDelete, target: "/api/estate/1?version=abc", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doUpdateRequest(e, tt.method, tt.target, tt.body); got != tt.want {
+				t.Errorf("unexpected status. expected: %v, but got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPutChair_Conflict(t *testing.T) {
+	setupTestDB(t)
+	setupChairSearchCondition(t)
+
+	insertTestChair(t, 4, "座椅子")
+	// 在庫 5 のうち 1 つが予約されている
+	_, err := db.Exec("INSERT INTO chair_reservation(token, chair_id, expires_at) VALUES(?, ?, ?)",
+		fmt.Sprintf("update-test-%d", time.Now().UnixNano()), testChairID, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal("failed to insert reservation:", err)
+	}
+
+	e := newUpdateTestServer()
+	target := fmt.Sprintf("/api/chair/%d", testChairID)
+	body := func(stock, version int64) string {
+		return fmt.Sprintf(`{"name":"renamed","thumbnail":"/images/chair/test.png","price":1000,"height":100,"width":100,"depth":100,"color":"黒","kind":"座椅子","stock":%d,"version":%d}`, stock, version)
+	}
+
+	if got := doUpdateRequest(e, http.MethodPut, target, body(10, 1)); got != http.StatusOK {
+		t.Fatalf("unexpected status. expected: %v, but got: %v", http.StatusOK, got)
+	}
+	var chair Chair
+	if err := db.Get(&chair, "SELECT "+chairColumns+" FROM chair WHERE id = ?", testChairID); err != nil {
+		t.Fatal(err)
+	}
+	if chair.Stock != 9 || chair.Version != 2 {
+		t.Errorf("unexpected stock and version. expected: 9, 2, but got: %v, %v", chair.Stock, chair.Version)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "stale version", body: body(10, 1), want: http.StatusConflict},
+		{name: "stock less than reserved", body: body(0, 2), want: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := doUpdateRequest(e, http.MethodPut, target, tt.body); got != tt.want {
+				t.Errorf("unexpected status. expected: %v, but got: %v", tt.want, got)
+			}
+		})
+	}
+
+	if got := doUpdateRequest(e, http.MethodPut, "/api/chair/2147483001", body(10, 1)); got != http.StatusNotFound {
+		t.Errorf("unexpected status for missing chair. expected: %v, but got: %v", http.StatusNotFound, got)
+	}
+	if got := doUpdateRequest(e, http.MethodDelete, target+"?version=1", ""); got != http.StatusConflict {
+		t.Errorf("unexpected status for stale delete. expected: %v, but got: %v", http.StatusConflict, got)
+	}
+}
+
+func TestPatchEstate_Conflict(t *testing.T) {
+	setupTestDB(t)
+
+	cleanup := func() {
+		db.Exec("DELETE FROM estate WHERE id = ?", testEstateID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	_, err := db.Exec("INSERT INTO estate(id, name, description, thumbnail, address, latitude, longitude, rent, door_height, door_width, features, popularity) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+		testEstateID, "test", "test", "/images/estate/test.png", "東京都", 35.681236, 139.767125, 100000, 100, 100, "", 0)
+	if err != nil {
+		t.Fatal("failed to insert estate:", err)
+	}
+
+	e := newUpdateTestServer()
+	target := fmt.Sprintf("/api/estate/%d", testEstateID)
+
+	if got := doUpdateRequest(e, http.MethodPatch, target, `{"rent":90000,"version":1}`); got != http.StatusOK {
+		t.Fatalf("unexpected status. expected: %v, but got: %v", http.StatusOK, got)
+	}
+	// 同じ version からの2回目の更新は、1回目の更新と競合する
+	if got := doUpdateRequest(e, http.MethodPatch, target, `{"rent":80000,"version":1}`); got != http.StatusConflict {
+		t.Errorf("unexpected status. expected: %v, but got: %v", http.StatusConflict, got)
+	}
+
+	var estate Estate
+	if err := db.Get(&estate, "SELECT "+estateColumns+" FROM estate WHERE id = ?", testEstateID); err != nil {
+		t.Fatal(err)
+	}
+	if estate.Rent != 90000 || estate.Version != 2 {
+		t.Errorf("unexpected rent and version. expected: 90000, 2, but got: %v, %v", estate.Rent, estate.Version)
+	}
+
+	if got := doUpdateRequest(e, http.MethodPatch, "/api/estate/2147483001", `{"rent":80000,"version":1}`); got != http.StatusNotFound {
+		t.Errorf("unexpected status for missing estate. expected: %v, but got: %v", http.StatusNotFound, got)
+	}
+	if got := doUpdateRequest(e, http.MethodDelete, target+"?version=1", ""); got != http.StatusConflict {
+		t.Errorf("unexpected status for stale delete. expected: %v, but got: %v", http.StatusConflict, got)
+	}
+	if got := doUpdateRequest(e, http.MethodDelete, target+"?version=2", ""); got != http.StatusNoContent {
+		t.Errorf("unexpected status for delete. expected: %v, but got: %v", http.StatusNoContent, got)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// validateString 0_Schema.sql の VARCHAR の長さに収まるか検証する
+func validateString(field, value string, maxLength int, required bool) error {
+	if required && value == "" {
+		return fmt.Errorf("%s is required", field)
+	}
+	if utf8.RuneCountInString(value) > maxLength {
+		return fmt.Errorf("%s is too long", field)
+	}
+	return nil
+}
+
+func validateListItem(field, value string, cond ListCondition) error {
+	for _, v := range cond.List {
+		if v == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown %s: %s", field, value)
+}
+
+func validateFeatures(features string, cond ListCondition) error {
+	if err := validateString("features", features, 64, false); err != nil {
+		return err
+	}
+	for _, f := range splitFeatures(features) {
+		if err := validateListItem("feature", f, cond); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateChair chair_condition.json の条件とテーブル定義に沿った値か検証する
+func validateChair(chair Chair) error {
+	if err := validateString("name", chair.Name, 64, true); err != nil {
+		return err
+	}
+	if err := validateString("description", chair.Description, 4096, false); err != nil {
+		return err
+	}
+	if err := validateString("thumbnail", chair.Thumbnail, 128, true); err != nil {
+		return err
+	}
+	if chair.ID < 0 || chair.Price < 0 || chair.Height < 0 || chair.Width < 0 || chair.Depth < 0 || chair.Popularity < 0 || chair.Stock < 0 {
+		return fmt.Errorf("numbers must not be negative")
+	}
+	if err := validateListItem("color", chair.Color, chairSearchCondition.Color); err != nil {
+		return err
+	}
+	if err := validateListItem("kind", chair.Kind, chairSearchCondition.Kind); err != nil {
+		return err
+	}
+	return validateFeatures(chair.Features, chairSearchCondition.Feature)
+}
+
+// validateEstate estate_condition.json の条件とテーブル定義に沿った値か検証する
+func validateEstate(estate Estate) error {
+	if err := validateString("name", estate.Name, 64, true); err != nil {
+		return err
+	}
+	if err := validateString("description", estate.Description, 4096, false); err != nil {
+		return err
+	}
+	if err := validateString("thumbnail", estate.Thumbnail, 128, true); err != nil {
+		return err
+	}
+	if err := validateString("address", estate.Address, 128, true); err != nil {
+		return err
+	}
+	if estate.Latitude < -90 || estate.Latitude > 90 || estate.Longitude < -180 || estate.Longitude > 180 {
+		return fmt.Errorf("invalid coordinate")
+	}
+	if estate.ID < 0 || estate.Rent < 0 || estate.DoorHeight < 0 || estate.DoorWidth < 0 || estate.Popularity < 0 {
+		return fmt.Errorf("numbers must not be negative")
+	}
+	return validateFeatures(estate.Features, estateSearchCondition.Feature)
+}
@@ -0,0 +1,130 @@
+DROP DATABASE IF EXISTS isuumo;
+CREATE DATABASE isuumo;
+
+DROP TABLE IF EXISTS isuumo.estate;
+DROP TABLE IF EXISTS isuumo.chair;
+DROP TABLE IF EXISTS isuumo.estate_feature;
+DROP TABLE IF EXISTS isuumo.chair_feature;
+DROP TABLE IF EXISTS isuumo.chair_order;
+DROP TABLE IF EXISTS isuumo.estate_document_request;
+DROP TABLE IF EXISTS isuumo.chair_buy_idempotency;
+DROP TABLE IF EXISTS isuumo.chair_reservation;
+
+CREATE TABLE isuumo.estate
+(
+    id          INTEGER             NOT NULL PRIMARY KEY,
+    name        VARCHAR(64)         NOT NULL,
+    description VARCHAR(4096)       NOT NULL,
+    thumbnail   VARCHAR(128)        NOT NULL,
+    address     VARCHAR(128)        NOT NULL,
+    latitude    DOUBLE PRECISION    NOT NULL,
+    longitude   DOUBLE PRECISION    NOT NULL,
+    rent        INTEGER             NOT NULL,
+    door_height INTEGER             NOT NULL,
+    door_width  INTEGER             NOT NULL,
+    features    VARCHAR(64)         NOT NULL,
+    popularity  INTEGER             NOT NULL,
+    -- 更新・削除の楽観的排他制御に使う。更新のたびに1ずつ増える
+    version     INTEGER             NOT NULL DEFAULT 1,
+    -- *_range_id は estate_condition.json の各 Range の ID で、アプリケーションが登録時に計算する
+    rent_range_id        INTEGER    NOT NULL DEFAULT -1,
+    door_height_range_id INTEGER    NOT NULL DEFAULT -1,
+    door_width_range_id  INTEGER    NOT NULL DEFAULT -1,
+    -- latitude, longitude から自動で計算されるため INSERT 時に指定する必要はない
+    point       POINT AS (POINT(latitude, longitude)) STORED NOT NULL,
+    SPATIAL INDEX idx_point (point),
+    FULLTEXT INDEX idx_keyword (name, description) WITH PARSER ngram,
+    INDEX idx_rent_range (rent_range_id, popularity),
+    INDEX idx_door_range (door_width_range_id, door_height_range_id, popularity)
+);
+
+CREATE TABLE isuumo.chair
+(
+    id          INTEGER         NOT NULL PRIMARY KEY,
+    name        VARCHAR(64)     NOT NULL,
+    description VARCHAR(4096)   NOT NULL,
+    thumbnail   VARCHAR(128)    NOT NULL,
+    price       INTEGER         NOT NULL,
+    height      INTEGER         NOT NULL,
+    width       INTEGER         NOT NULL,
+    depth       INTEGER         NOT NULL,
+    color       VARCHAR(64)     NOT NULL,
+    features    VARCHAR(64)     NOT NULL,
+    kind        VARCHAR(64)     NOT NULL,
+    popularity  INTEGER         NOT NULL,
+    stock       INTEGER         NOT NULL,
+    -- 更新・削除の楽観的排他制御に使う。更新のたびに1ずつ増える
+    version     INTEGER         NOT NULL DEFAULT 1,
+    -- *_range_id は chair_condition.json の各 Range の ID で、アプリケーションが登録時に計算する
+    price_range_id  INTEGER     NOT NULL DEFAULT -1,
+    height_range_id INTEGER     NOT NULL DEFAULT -1,
+    width_range_id  INTEGER     NOT NULL DEFAULT -1,
+    depth_range_id  INTEGER     NOT NULL DEFAULT -1,
+    FULLTEXT INDEX idx_keyword (name, description) WITH PARSER ngram,
+    INDEX idx_price_range (price_range_id, popularity),
+    INDEX idx_size_range (height_range_id, width_range_id, depth_range_id, popularity)
+);
+
+-- feature_id は estate_condition.json, chair_condition.json の feature.list 内の位置
+CREATE TABLE isuumo.estate_feature
+(
+    estate_id   INTEGER         NOT NULL,
+    feature_id  INTEGER         NOT NULL,
+    PRIMARY KEY (feature_id, estate_id),
+    INDEX idx_estate_id (estate_id)
+);
+
+CREATE TABLE isuumo.chair_feature
+(
+    chair_id    INTEGER         NOT NULL,
+    feature_id  INTEGER         NOT NULL,
+    PRIMARY KEY (feature_id, chair_id),
+    INDEX idx_chair_id (chair_id)
+);
+
+CREATE TABLE isuumo.chair_order
+(
+    id              BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    chair_id        INTEGER         NOT NULL,
+    quantity        INTEGER         NOT NULL DEFAULT 1,
+    email           VARCHAR(256)    NOT NULL,
+    created_at      DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_email (email, created_at)
+);
+
+-- イスの購入の Idempotency-Key と、そのキーで最初に返したステータスコード
+-- キーは購入者 (email) ごとに区別し、同じ購入者の同じキーでの再送には在庫を減らさずに status を返す
+CREATE TABLE isuumo.chair_buy_idempotency
+(
+    email           VARCHAR(256)    NOT NULL,
+    idempotency_key VARCHAR(128)    NOT NULL,
+    chair_id        INTEGER         NOT NULL,
+    status          INTEGER         NOT NULL DEFAULT 0,
+    created_at      DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    PRIMARY KEY (email, idempotency_key)
+);
+
+-- idempotency_key はクライアントが Idempotency-Key ヘッダで指定した値で、同じ申込者 (email) の同じキーでの重複した申し込みを防ぐ
+CREATE TABLE isuumo.estate_document_request
+(
+    id              BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    estate_id       INTEGER         NOT NULL,
+    email           VARCHAR(256)    NOT NULL,
+    idempotency_key VARCHAR(128)    NULL,
+    created_at      DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE INDEX idx_idempotency_key (email, idempotency_key),
+    INDEX idx_email (email, created_at)
+);
+
+-- 予約中の在庫は chair.stock から差し引かれており、期限切れの予約はアプリケーションが在庫に戻す
+CREATE TABLE isuumo.chair_reservation
+(
+    id          BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token       VARCHAR(64)     NOT NULL,
+    chair_id    INTEGER         NOT NULL,
+    expires_at  DATETIME(6)     NOT NULL,
+    created_at  DATETIME(6)     NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    UNIQUE INDEX idx_token (token),
+    INDEX idx_chair_id (chair_id),
+    INDEX idx_expires_at (expires_at)
+);